@@ -0,0 +1,229 @@
+package echogy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/youkale/echogy/logger"
+)
+
+// TunnelStatus describes a single established tunnel
+type TunnelStatus struct {
+	AccessId    string    `json:"accessId"`
+	URL         string    `json:"url"`
+	RemoteAddr  string    `json:"remoteAddr"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Connections int64     `json:"connections"`
+	BytesIn     int64     `json:"bytesIn"`
+	BytesOut    int64     `json:"bytesOut"`
+	Pool        string    `json:"pool,omitempty"` // balance policy when the tunnel is one of a pool
+}
+
+// ServerStatus is the payload served by the admin status endpoint
+type ServerStatus struct {
+	Version   string         `json:"version"`
+	StartedAt time.Time      `json:"startedAt"`
+	Uptime    string         `json:"uptime"`
+	Tunnels   []TunnelStatus `json:"tunnels"`
+	// Rejected counts the facade connections ended by the limits, by reason
+	Rejected map[string]int64 `json:"rejected"`
+}
+
+func tunnelStatuses() []TunnelStatus {
+	tunnels := make([]TunnelStatus, 0)
+	sessionHub.Range(func(key, value any) bool {
+		members := []*forwarder{value.(*forwarder)}
+		if pool := members[0].pool; nil != pool {
+			members = pool.snapshot()
+		}
+		for _, fwd := range members {
+			status := TunnelStatus{
+				AccessId:    fwd.accessId,
+				URL:         fwd.url,
+				RemoteAddr:  fwd.sess.RemoteAddr().String(),
+				CreatedAt:   fwd.createdAt,
+				Connections: fwd.connCount.Load(),
+				BytesIn:     fwd.bytesIn.Load(),
+				BytesOut:    fwd.bytesOut.Load(),
+			}
+			if nil != fwd.pool {
+				status.Pool = fwd.pool.policy
+			}
+			tunnels = append(tunnels, status)
+		}
+		return true
+	})
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].CreatedAt.Before(tunnels[j].CreatedAt)
+	})
+	return tunnels
+}
+
+// rejectionCounts returns the rejection counters by reason, as labeled in the metrics
+func rejectionCounts() map[string]int64 {
+	return map[string]int64{
+		"header_timeout":        rejections.headerTimeout.Load(),
+		"header_too_large":      rejections.headerTooLarge.Load(),
+		"request_line_too_long": rejections.requestLineTooLong.Load(),
+		"idle":                  rejections.idle.Load(),
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warn("encode admin response", map[string]interface{}{
+			"module": "admin",
+			"error":  err.Error(),
+		})
+	}
+}
+
+// writeMetrics writes the traffic counters in the prometheus text format
+func writeMetrics(w io.Writer) {
+	tunnels := tunnelStatuses()
+	fmt.Fprintf(w, "# HELP echogy_tunnels Established tunnels.\n# TYPE echogy_tunnels gauge\nechogy_tunnels %d\n", len(tunnels))
+	fmt.Fprintf(w, "# HELP echogy_received_bytes_total Bytes read from facade clients.\n# TYPE echogy_received_bytes_total counter\n")
+	fmt.Fprintf(w, "echogy_received_bytes_total %d\n", totalBytesIn.Load())
+	fmt.Fprintf(w, "# HELP echogy_sent_bytes_total Bytes read from tunnels for facade clients.\n# TYPE echogy_sent_bytes_total counter\n")
+	fmt.Fprintf(w, "echogy_sent_bytes_total %d\n", totalBytesOut.Load())
+
+	fmt.Fprintf(w, "# HELP echogy_facade_rejected_total Facade connections ended by the limits.\n# TYPE echogy_facade_rejected_total counter\n")
+	rejected := rejectionCounts()
+	reasons := make([]string, 0, len(rejected))
+	for reason := range rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "echogy_facade_rejected_total{reason=%q} %d\n", reason, rejected[reason])
+	}
+
+	fmt.Fprintf(w, "# HELP echogy_split_requests_total Connections a split sent to its targets.\n# TYPE echogy_split_requests_total counter\n")
+	for _, split := range splitStatuses() {
+		for _, target := range split.Targets {
+			fmt.Fprintf(w, "echogy_split_requests_total{split=%q,tunnel=%q} %d\n", split.Name, target.Tunnel, target.Requests)
+		}
+	}
+
+	perTunnel := []struct {
+		name, help string
+		value      func(TunnelStatus) int64
+	}{
+		{"echogy_tunnel_connections_total", "Facade connections forwarded to the tunnel.", func(t TunnelStatus) int64 { return t.Connections }},
+		{"echogy_tunnel_received_bytes_total", "Bytes read from facade clients of the tunnel.", func(t TunnelStatus) int64 { return t.BytesIn }},
+		{"echogy_tunnel_sent_bytes_total", "Bytes read from the tunnel for facade clients.", func(t TunnelStatus) int64 { return t.BytesOut }},
+	}
+	for _, m := range perTunnel {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name)
+		for _, t := range tunnels {
+			if t.Pool != "" {
+				// members of a pool share the name
+				fmt.Fprintf(w, "%s{tunnel=%q,member=%q} %d\n", m.name, t.AccessId, t.RemoteAddr, m.value(t))
+				continue
+			}
+			fmt.Fprintf(w, "%s{tunnel=%q} %d\n", m.name, t.AccessId, m.value(t))
+		}
+	}
+}
+
+func newAdminMux(version string, startedAt time.Time) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, &ServerStatus{
+			Version:   version,
+			StartedAt: startedAt,
+			Uptime:    time.Since(startedAt).Truncate(time.Second).String(),
+			Tunnels:   tunnelStatuses(),
+			Rejected:  rejectionCounts(),
+		})
+	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+	mux.HandleFunc("GET /api/splits", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, splitStatuses())
+	})
+	mux.HandleFunc("GET /api/splits/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		value, found := splits.Load(name)
+		if !found {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "split " + name + " not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, value.(*trafficSplit).status())
+	})
+	mux.HandleFunc("PUT /api/splits/{name}", func(w http.ResponseWriter, r *http.Request) {
+		split := Split{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&split); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		split.Name = r.PathValue("name")
+		if err := setSplit(split, ""); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		value, _ := splits.Load(split.Name)
+		writeJSON(w, http.StatusOK, value.(*trafficSplit).status())
+	})
+	mux.HandleFunc("DELETE /api/splits/{name}", func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		if !deleteSplit(name) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "split " + name + " not found"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /api/tunnels/{id}/har", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		value, found := sessionHub.Load(id)
+		if !found {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "tunnel " + id + " not found"})
+			return
+		}
+		fwd := value.(*forwarder)
+		if nil == fwd.pty {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "tunnel " + id + " has no dashboard, traffic is not recorded"})
+			return
+		}
+		data, err := fwd.pty.HAR()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+id+`.har"`)
+		w.Write(data)
+	})
+	return mux
+}
+
+func adminServe(ctx context.Context, addr, version string) {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           newAdminMux(version, time.Now()),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	logger.Warn("started admin server", map[string]interface{}{
+		"module":  "admin",
+		"address": addr,
+	})
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("admin server", err, map[string]interface{}{
+			"module":  "admin",
+			"address": addr,
+		})
+	}
+}
@@ -20,7 +20,7 @@ fi
 # Start the application
 cd "$APP_DIR"
 echo "Starting $APP_NAME..."
-"$APP_DIR/$APP_NAME" -c "$CONFIG_FILE" -pid "$PID_FILE" > /dev/null 2>&1 &
+"$APP_DIR/$APP_NAME" serve -c "$CONFIG_FILE" -pid "$PID_FILE" > /dev/null 2>&1 &
 
 # Wait a moment and check if the process is running
 sleep 1
@@ -0,0 +1,305 @@
+// Package client dials an echogy server and serves a tunnel from Go,
+// without relying on an ssh binary.
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/youkale/echogy/logger"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultUser       = "echogy"
+	defaultMinBackoff = 500 * time.Millisecond
+	defaultMaxBackoff = 30 * time.Second
+	dialTimeout       = 10 * time.Second
+	readyTimeout      = 10 * time.Second
+)
+
+// Config describes the tunnel a Client establishes
+type Config struct {
+	// ServerAddr is the host:port of the echogy ssh server
+	ServerAddr string
+	// User is the ssh user name, defaults to "echogy"
+	User string
+	// Auth methods offered to the server, the "none" method is tried when empty
+	Auth []gossh.AuthMethod
+	// HostKeyCallback verifies the server host key, it is required
+	HostKeyCallback gossh.HostKeyCallback
+	// LocalAddr is where forwarded connections are dialed to, e.g. ":3000"
+	LocalAddr string
+	// Name is the requested tunnel name, the server picks one when empty.
+	// Either way the name is kept across reconnects.
+	Name string
+	// Options are key=value tunnel options, e.g. "capture=off"
+	Options []string
+	// MinBackoff and MaxBackoff bound the delay between reconnect attempts
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnReady is called with the public url every time the tunnel is (re)established
+	OnReady func(url string)
+}
+
+// Client keeps a tunnel to an echogy server alive
+type Client struct {
+	conf *Config
+
+	mu   sync.RWMutex
+	name string
+	url  string
+}
+
+type remoteForwardRequest struct {
+	BindAddr string
+	BindPort uint32
+}
+
+type forwardedTCPPayload struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// New creates a Client, call Run to establish the tunnel
+func New(conf *Config) *Client {
+	c := *conf
+	if c.User == "" {
+		c.User = defaultUser
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = defaultMinBackoff
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		c.MaxBackoff = max(defaultMaxBackoff, c.MinBackoff)
+	}
+	return &Client{conf: &c, name: strings.ToLower(c.Name)}
+}
+
+// URL returns the public url of the tunnel, empty until it is first established
+func (c *Client) URL() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.url
+}
+
+// Name returns the tunnel name, which is reused on every reconnect
+func (c *Client) Name() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.name
+}
+
+// Run connects to the server and keeps reconnecting with exponential backoff
+// until ctx is cancelled. It only returns early on configuration errors.
+func (c *Client) Run(ctx context.Context) error {
+	if c.conf.HostKeyCallback == nil {
+		return errors.New("client: HostKeyCallback is required")
+	}
+	if c.conf.LocalAddr == "" {
+		return errors.New("client: LocalAddr is required")
+	}
+
+	backoff := c.conf.MinBackoff
+	for {
+		established, err := c.connect(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if established {
+			backoff = c.conf.MinBackoff
+		}
+		logger.Warn("tunnel disconnected", map[string]interface{}{
+			"module": "client",
+			"server": c.conf.ServerAddr,
+			"name":   c.Name(),
+			"retry":  backoff.String(),
+			"error":  fmt.Sprint(err),
+		})
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, c.conf.MaxBackoff)
+	}
+}
+
+// connect runs a single ssh connection, it reports whether the tunnel was established
+func (c *Client) connect(ctx context.Context) (bool, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", c.conf.ServerAddr)
+	if err != nil {
+		return false, err
+	}
+	sshConn, chans, reqs, err := gossh.NewClientConn(conn, c.conf.ServerAddr, &gossh.ClientConfig{
+		User:            c.conf.User,
+		Auth:            c.conf.Auth,
+		HostKeyCallback: c.conf.HostKeyCallback,
+		Timeout:         dialTimeout,
+	})
+	if err != nil {
+		conn.Close()
+		return false, err
+	}
+	sshClient := gossh.NewClient(sshConn, chans, reqs)
+	defer sshClient.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		sshClient.Close()
+	})
+	defer stop()
+
+	forwards := sshClient.HandleChannelOpen("forwarded-tcpip")
+	ok, _, err := sshClient.SendRequest("tcpip-forward", true, gossh.Marshal(&remoteForwardRequest{
+		BindAddr: c.Name(),
+		BindPort: 80,
+	}))
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, errors.New("server rejected tcpip-forward request")
+	}
+
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return false, err
+	}
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	if len(c.conf.Options) > 0 {
+		err = session.Start(sessionCommand(c.conf.Options))
+	} else {
+		err = session.Shell()
+	}
+	if err != nil {
+		return false, err
+	}
+
+	publicURL, err := readURL(stdout)
+	if err != nil {
+		return false, err
+	}
+	c.ready(publicURL)
+
+	go func() {
+		for ch := range forwards {
+			go c.handleForward(ch)
+		}
+	}()
+	go io.Copy(io.Discard, stdout)
+
+	return true, sshClient.Wait()
+}
+
+func (c *Client) ready(publicURL *url.URL) {
+	c.mu.Lock()
+	c.url = publicURL.String()
+	if c.name == "" {
+		c.name, _, _ = strings.Cut(publicURL.Hostname(), ".")
+	}
+	c.mu.Unlock()
+
+	logger.Info("tunnel established", map[string]interface{}{
+		"module": "client",
+		"url":    publicURL.String(),
+		"local":  c.conf.LocalAddr,
+	})
+	if c.conf.OnReady != nil {
+		c.conf.OnReady(publicURL.String())
+	}
+}
+
+// readURL reads the banner a headless session starts with, the first line is the public url
+func readURL(r io.Reader) (*url.URL, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		line, err := bufio.NewReader(r).ReadString('\n')
+		done <- result{strings.TrimSpace(line), err}
+	}()
+
+	select {
+	case res := <-done:
+		if !strings.Contains(res.line, "://") {
+			if res.line == "" && res.err != nil {
+				return nil, res.err
+			}
+			return nil, fmt.Errorf("server: %s", res.line)
+		}
+		return url.Parse(res.line)
+	case <-time.After(readyTimeout):
+		return nil, errors.New("timed out waiting for the tunnel url")
+	}
+}
+
+func (c *Client) handleForward(ch gossh.NewChannel) {
+	payload := &forwardedTCPPayload{}
+	if err := gossh.Unmarshal(ch.ExtraData(), payload); err != nil {
+		ch.Reject(gossh.ConnectionFailed, "could not parse forwarded-tcpip payload")
+		return
+	}
+
+	local, err := net.DialTimeout("tcp", c.conf.LocalAddr, dialTimeout)
+	if err != nil {
+		logger.Warn("dial local service", map[string]interface{}{
+			"module": "client",
+			"local":  c.conf.LocalAddr,
+			"origin": net.JoinHostPort(payload.OriginAddr, fmt.Sprint(payload.OriginPort)),
+			"error":  err.Error(),
+		})
+		// the server shows this on the dashboard of the tunnel
+		reason := fmt.Sprintf("local service on %s unreachable: %v", c.conf.LocalAddr, err)
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			reason = fmt.Sprintf("local service on %s refused connection", c.conf.LocalAddr)
+		}
+		ch.Reject(gossh.ConnectionFailed, reason)
+		return
+	}
+
+	channel, reqs, err := ch.Accept()
+	if err != nil {
+		local.Close()
+		return
+	}
+	go gossh.DiscardRequests(reqs)
+
+	go func() {
+		io.Copy(channel, local)
+		channel.CloseWrite()
+	}()
+	io.Copy(local, channel)
+	local.Close()
+	channel.Close()
+}
+
+// sessionCommand joins the tunnel options into the session command, which the server splits
+// like a shell does, options with spaces such as req-set=X-Team:web ui are quoted
+func sessionCommand(options []string) string {
+	quoted := make([]string, len(options))
+	for i, option := range options {
+		if strings.ContainsAny(option, " \t'\"\\") {
+			option = "'" + strings.ReplaceAll(option, "'", `'\''`) + "'"
+		}
+		quoted[i] = option
+	}
+	return strings.Join(quoted, " ")
+}
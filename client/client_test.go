@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youkale/echogy"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const testDomain = "echogy.test"
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func hostKey(t *testing.T) []byte {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := gossh.MarshalPrivateKey(key, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(block)
+}
+
+// startServer runs an in-process echogy server and returns its ssh and http addresses
+func startServer(t *testing.T) (string, string) {
+	t.Helper()
+	sshAddr, httpAddr := freeAddr(t), freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go echogy.Serve(ctx, &echogy.Options{
+		SSHAddr:    sshAddr,
+		HttpAddr:   httpAddr,
+		Domain:     testDomain,
+		PrivateKey: hostKey(t),
+	})
+	waitListening(t, sshAddr)
+	waitListening(t, httpAddr)
+	return sshAddr, httpAddr
+}
+
+func waitListening(t *testing.T, addr string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if c, err := net.Dial("tcp", addr); err == nil {
+			c.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("%s is not listening", addr)
+}
+
+// getVia sends a request for host through the facade listening on httpAddr
+func getVia(t *testing.T, httpAddr, host, path string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, "http://"+httpAddr+path, nil)
+	req.Host = host
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestClientTunnel(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}))
+	defer backend.Close()
+
+	sshAddr, httpAddr := startServer(t)
+
+	ready := make(chan string, 4)
+	c := New(&Config{
+		ServerAddr:      sshAddr,
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		LocalAddr:       backend.Listener.Addr().String(),
+		Name:            "myapp",
+		OnReady:         func(url string) { ready <- url },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	select {
+	case url := <-ready:
+		if url != "https://myapp."+testDomain {
+			t.Fatalf("url = %s, want https://myapp.%s", url, testDomain)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel was not established")
+	}
+
+	for _, path := range []string{"/", "/a", "/b"} {
+		status, body := getVia(t, httpAddr, "myapp."+testDomain, path)
+		if status != http.StatusOK || body != "hello "+path {
+			t.Errorf("GET %s = %d %q, want 200 %q", path, status, body, "hello "+path)
+		}
+	}
+
+	if c.Name() != "myapp" {
+		t.Errorf("Name() = %s, want myapp", c.Name())
+	}
+}
+
+func TestClientNameInUse(t *testing.T) {
+	sshAddr, _ := startServer(t)
+
+	first := New(&Config{
+		ServerAddr:      sshAddr,
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		LocalAddr:       "127.0.0.1:1",
+		Name:            "taken",
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if established, err := first.connectOnce(ctx); !established {
+		t.Fatalf("first client: %v", err)
+	}
+
+	second := New(&Config{
+		ServerAddr:      sshAddr,
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		LocalAddr:       "127.0.0.1:1",
+		Name:            "taken",
+	})
+	established, err := second.connectOnce(ctx)
+	if established || err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("second client established=%v err=%v, want name in use error", established, err)
+	}
+}
+
+// connectOnce establishes the tunnel and returns without waiting for it to close
+func (c *Client) connectOnce(ctx context.Context) (bool, error) {
+	ready := make(chan struct{})
+	c.conf.OnReady = func(string) { close(ready) }
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.connect(ctx)
+		errc <- err
+	}()
+	select {
+	case <-ready:
+		return true, nil
+	case err := <-errc:
+		return false, err
+	case <-time.After(5 * time.Second):
+		return false, fmt.Errorf("timed out")
+	}
+}
+
+func TestClientLocalServiceDown(t *testing.T) {
+	sshAddr, httpAddr := startServer(t)
+	local := freeAddr(t)
+
+	ready := make(chan string, 4)
+	c := New(&Config{
+		ServerAddr:      sshAddr,
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		LocalAddr:       local,
+		Name:            "down",
+		OnReady:         func(url string) { ready <- url },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel was not established")
+	}
+
+	// the tunnel answers for the local service and stays up
+	for i := 0; i < 2; i++ {
+		status, body := getVia(t, httpAddr, "down."+testDomain, "/")
+		if status != http.StatusBadGateway || !strings.Contains(body, "Request ID") {
+			t.Fatalf("GET with the local service down = %d %q", status, body)
+		}
+	}
+
+	ln, err := net.Listen("tcp", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "up")
+	})}
+	go backend.Serve(ln)
+	defer backend.Close()
+
+	if status, body := getVia(t, httpAddr, "down."+testDomain, "/"); status != http.StatusOK || body != "up" {
+		t.Errorf("GET once the local service is up = %d %q", status, body)
+	}
+}
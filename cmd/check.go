@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func runCheckConfig(args []string) int {
+	fs := newFlagSet("check-config", "[-c config.json]",
+		"Parse and validate a config file, exiting non-zero when it has errors.")
+	conf := fs.String("c", defaultConfigFile, "config file, format json")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	config, err := loadConfig(*conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "echogy: %v\n", err)
+		return 1
+	}
+	errs := config.Validate()
+	for _, e := range errs {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", *conf, e)
+	}
+	if len(errs) > 0 {
+		return 1
+	}
+	fmt.Printf("%s: ok\n", *conf)
+	return 0
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/youkale/echogy"
+	"github.com/youkale/echogy/pkg/errorpage"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+const defaultConfigFile = "config.json"
+
+type Config struct {
+	LogLevel           string              `json:"logLevel"`
+	LogFile            string              `json:"logFile"` // Path to log file
+	EnablePProf        bool                `json:"pprof"`
+	HttpAddr           string              `json:"httpAddr"`
+	HttpsAddr          string              `json:"httpsAddr"` // facade over TLS with HTTP/2, disabled when empty
+	TLSCert            string              `json:"tlsCert"`   // certificate file for httpsAddr
+	TLSKey             string              `json:"tlsKey"`    // private key file for httpsAddr
+	SSHAddr            string              `json:"SSHAddr"`
+	AdminAddr          string              `json:"adminAddr"` // admin api, disabled when empty
+	Domain             string              `json:"domain"`
+	Domains            []DomainConfig      `json:"domains"`            // more apex domains tunnels are reachable under
+	SubdomainDepth     int                 `json:"subdomainDepth"`     // labels allowed under a domain, 1 when unset
+	ErrorPages         string              `json:"errorPages"`         // directory of error page templates
+	ChannelOpenTimeout string              `json:"channelOpenTimeout"` // e.g. "10s", how long a connection may wait for the local service
+	ReadHeaderTimeout  string              `json:"readHeaderTimeout"`  // e.g. "10s", how long a client may take to send the request headers
+	MaxHeaderBytes     int                 `json:"maxHeaderBytes"`     // request line and headers, 1MB when unset
+	MaxRequestLine     int                 `json:"maxRequestLine"`     // 8KB when unset
+	IdleTimeout        string              `json:"idleTimeout"`        // e.g. "2m", a connection carrying nothing either way is closed
+	TunnelOptions      map[string][]string `json:"tunnelOptions"`      // options by tunnel name, applied before those of the session
+	PrivateKey         string              `json:"privateKey"`
+}
+
+var logLevels = map[string]zerolog.Level{
+	"debug": zerolog.DebugLevel,
+	"info":  zerolog.InfoLevel,
+	"warn":  zerolog.WarnLevel,
+	"error": zerolog.ErrorLevel,
+	"fatal": zerolog.FatalLevel,
+	"panic": zerolog.PanicLevel,
+}
+
+func logLevel(level string) zerolog.Level {
+	if l, ok := logLevels[level]; ok {
+		return l
+	}
+	return zerolog.WarnLevel
+}
+
+// loadConfig reads and decodes a json config file
+func loadConfig(path string) (*Config, error) {
+	f, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := json.Unmarshal(f, config); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return config, nil
+}
+
+func validateAddr(field, addr string, required bool) error {
+	if addr == "" {
+		if required {
+			return fmt.Errorf("%s is required", field)
+		}
+		return nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("%s %q: %v", field, addr, err)
+	}
+	return nil
+}
+
+// DomainConfig is an extra apex domain, a plain string in the config is just its name
+type DomainConfig struct {
+	Name          string `json:"name"`
+	TLSCert       string `json:"tlsCert"` // the default tlsCert and tlsKey when empty
+	TLSKey        string `json:"tlsKey"`
+	SSHAddr       string `json:"sshAddr"`       // tunnels opened on this listener only land on the domain
+	SSHUserSuffix string `json:"sshUserSuffix"` // tunnels of ssh users ending with it only land on the domain
+}
+
+func (d *DomainConfig) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &d.Name); err == nil {
+		return nil
+	}
+	type plain DomainConfig
+	return json.Unmarshal(data, (*plain)(d))
+}
+
+// maxSubdomainDepth bounds how many labels a host may have under a domain
+const maxSubdomainDepth = 4
+
+func validateDomain(field, domain string) error {
+	if domain == "" || strings.ContainsAny(domain, "/: ") || domain != strings.ToLower(domain) {
+		return fmt.Errorf("%s %q must be a bare lowercase host name", field, domain)
+	}
+	return nil
+}
+
+// Validate reports every problem found in the config
+func (c *Config) Validate() []error {
+	var errs []error
+	if c.LogLevel != "" {
+		if _, ok := logLevels[c.LogLevel]; !ok {
+			errs = append(errs, fmt.Errorf("logLevel %q is not one of debug, info, warn, error, fatal, panic", c.LogLevel))
+		}
+	}
+	if err := validateAddr("httpAddr", c.HttpAddr, true); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateAddr("httpsAddr", c.HttpsAddr, false); err != nil {
+		errs = append(errs, err)
+	} else if c.HttpsAddr != "" {
+		if c.TLSCert == "" || c.TLSKey == "" {
+			errs = append(errs, errors.New("httpsAddr needs tlsCert and tlsKey"))
+		} else if _, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey); err != nil {
+			errs = append(errs, fmt.Errorf("tlsCert/tlsKey: %v", err))
+		}
+	}
+	if err := validateAddr("SSHAddr", c.SSHAddr, true); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateAddr("adminAddr", c.AdminAddr, false); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Domain == "" {
+		errs = append(errs, errors.New("domain is required"))
+	} else if err := validateDomain("domain", c.Domain); err != nil {
+		errs = append(errs, err)
+	}
+	for _, domain := range c.Domains {
+		if err := validateDomain("domains", domain.Name); err != nil {
+			errs = append(errs, err)
+		}
+		field := fmt.Sprintf("domains %s sshAddr", domain.Name)
+		if err := validateAddr(field, domain.SSHAddr, false); err != nil {
+			errs = append(errs, err)
+		}
+		if (domain.TLSCert == "") != (domain.TLSKey == "") {
+			errs = append(errs, fmt.Errorf("domains %s needs both tlsCert and tlsKey", domain.Name))
+		} else if domain.TLSCert != "" {
+			if _, err := tls.LoadX509KeyPair(domain.TLSCert, domain.TLSKey); err != nil {
+				errs = append(errs, fmt.Errorf("domains %s tlsCert/tlsKey: %v", domain.Name, err))
+			}
+		}
+	}
+	if c.SubdomainDepth < 0 || c.SubdomainDepth > maxSubdomainDepth {
+		errs = append(errs, fmt.Errorf("subdomainDepth %d must be 0 for the default, or 1..%d", c.SubdomainDepth, maxSubdomainDepth))
+	}
+	if c.ErrorPages != "" {
+		if _, err := errorpage.Load(c.ErrorPages); err != nil {
+			errs = append(errs, fmt.Errorf("errorPages: %v", err))
+		}
+	}
+	if _, err := c.channelOpenTimeout(); err != nil {
+		errs = append(errs, fmt.Errorf("channelOpenTimeout: %v", err))
+	}
+	if _, err := positiveDuration(c.ReadHeaderTimeout); err != nil {
+		errs = append(errs, fmt.Errorf("readHeaderTimeout: %v", err))
+	}
+	if _, err := positiveDuration(c.IdleTimeout); err != nil {
+		errs = append(errs, fmt.Errorf("idleTimeout: %v", err))
+	}
+	if c.MaxHeaderBytes < 0 {
+		errs = append(errs, fmt.Errorf("maxHeaderBytes %d must be positive", c.MaxHeaderBytes))
+	}
+	if c.MaxRequestLine < 0 {
+		errs = append(errs, fmt.Errorf("maxRequestLine %d must be positive", c.MaxRequestLine))
+	} else if c.MaxHeaderBytes > 0 && c.MaxRequestLine > c.MaxHeaderBytes {
+		errs = append(errs, fmt.Errorf("maxRequestLine %d must not exceed maxHeaderBytes %d", c.MaxRequestLine, c.MaxHeaderBytes))
+	}
+	for name, args := range c.TunnelOptions {
+		if err := echogy.ValidateTunnelOptions(name, args); err != nil {
+			errs = append(errs, fmt.Errorf("tunnelOptions %s: %v", name, err))
+		}
+	}
+	if c.PrivateKey == "" {
+		errs = append(errs, errors.New("privateKey is required, generate one with `echogy keygen`"))
+	} else if _, err := gossh.ParsePrivateKey([]byte(c.PrivateKey)); err != nil {
+		errs = append(errs, fmt.Errorf("privateKey: %v", err))
+	}
+	return errs
+}
+
+// domains converts the extra domains for echogy.Options
+func (c *Config) domains() []echogy.Domain {
+	domains := make([]echogy.Domain, 0, len(c.Domains))
+	for _, d := range c.Domains {
+		domains = append(domains, echogy.Domain{
+			Name:          d.Name,
+			TLSCert:       d.TLSCert,
+			TLSKey:        d.TLSKey,
+			SSHAddr:       d.SSHAddr,
+			SSHUserSuffix: d.SSHUserSuffix,
+		})
+	}
+	return domains
+}
+
+// channelOpenTimeout parses the channel open timeout, zero keeps the default
+func (c *Config) channelOpenTimeout() (time.Duration, error) {
+	return positiveDuration(c.ChannelOpenTimeout)
+}
+
+// positiveDuration parses an optional duration, zero when empty
+func positiveDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("%s must be positive", value)
+	}
+	return d, err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/youkale/echogy/client"
+	"github.com/youkale/echogy/logger"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// stringsFlag collects the values of a repeatable flag
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, " ")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// hostKeyCallback picks the host key verification strategy for connect
+func hostKeyCallback(insecure bool, fingerprint, knownHostsFile string) (gossh.HostKeyCallback, error) {
+	switch {
+	case insecure:
+		return gossh.InsecureIgnoreHostKey(), nil
+	case fingerprint != "":
+		return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+			if got := gossh.FingerprintSHA256(key); got != fingerprint {
+				return fmt.Errorf("host key fingerprint %s does not match %s", got, fingerprint)
+			}
+			return nil
+		}, nil
+	default:
+		if knownHostsFile == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		return knownhosts.New(knownHostsFile)
+	}
+}
+
+func runConnect(args []string) int {
+	fs := newFlagSet("connect", "--local :3000 [--name myapp] [--server host:port]",
+		"Open a tunnel to a local service and keep it alive, reconnecting with backoff\n"+
+			"under the same name when the connection drops.")
+	server := fs.String("server", "webs.sh:22", "echogy ssh server address")
+	local := fs.String("local", "", "local address to forward traffic to, e.g. :3000")
+	name := fs.String("name", "", "requested tunnel name, the server picks one when empty")
+	user := fs.String("user", "echogy", "ssh user name")
+	identity := fs.String("i", "", "private key file used to authenticate")
+	fingerprint := fs.String("host-key", "", "expected SHA256 fingerprint of the server host key")
+	knownHosts := fs.String("known-hosts", "", "known_hosts file (default ~/.ssh/known_hosts)")
+	insecure := fs.Bool("insecure", false, "skip host key verification")
+	level := fs.String("log-level", "info", "log level")
+	var options stringsFlag
+	fs.Var(&options, "opt", "tunnel option in key=value form, e.g. capture=off (repeatable)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *local == "" {
+		fmt.Fprintf(os.Stderr, "echogy: --local is required\n")
+		fs.Usage()
+		return 2
+	}
+
+	logger.SetLogLevel(logLevel(*level))
+
+	hostKey, err := hostKeyCallback(*insecure, *fingerprint, *knownHosts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "echogy: host key verification: %v\n", err)
+		return 1
+	}
+
+	var auth []gossh.AuthMethod
+	if *identity != "" {
+		pem, err := os.ReadFile(*identity)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "echogy: %v\n", err)
+			return 1
+		}
+		signer, err := gossh.ParsePrivateKey(pem)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "echogy: %s: %v\n", *identity, err)
+			return 1
+		}
+		auth = append(auth, gossh.PublicKeys(signer))
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	c := client.New(&client.Config{
+		ServerAddr:      *server,
+		User:            *user,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+		LocalAddr:       *local,
+		Name:            *name,
+		Options:         options,
+		OnReady: func(url string) {
+			fmt.Printf("Forwarding %s -> %s\n", url, *local)
+		},
+	})
+	if err := c.Run(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "echogy: %v\n", err)
+		return 1
+	}
+	return 0
+}
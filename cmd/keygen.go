@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func generateHostKey(keyType string, bits int) (crypto.Signer, error) {
+	switch keyType {
+	case "ed25519":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case "rsa":
+		if bits < 2048 {
+			return nil, fmt.Errorf("rsa keys need at least 2048 bits, got %d", bits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	case "ecdsa":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		return nil, fmt.Errorf("unknown key type %q, want ed25519, rsa or ecdsa", keyType)
+	}
+}
+
+func runKeygen(args []string) int {
+	fs := newFlagSet("keygen", "[-t ed25519|rsa|ecdsa] [-b bits] [-o file] [-json]",
+		"Generate an OpenSSH formatted host key for the privateKey config field.\n"+
+			"The key is written to stdout unless -o is given, the fingerprint goes to stderr.")
+	keyType := fs.String("t", "ed25519", "key type: ed25519, rsa or ecdsa")
+	bits := fs.Int("b", 4096, "key size in bits, rsa only")
+	output := fs.String("o", "", "write the private key to this file instead of stdout")
+	comment := fs.String("C", "echogy", "key comment")
+	asJSON := fs.Bool("json", false, "print the key as a json string, ready to paste into config.json")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	key, err := generateHostKey(*keyType, *bits)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "echogy: %v\n", err)
+		return 1
+	}
+	block, err := gossh.MarshalPrivateKey(key, *comment)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "echogy: marshal private key: %v\n", err)
+		return 1
+	}
+	out := pem.EncodeToMemory(block)
+	if *asJSON {
+		quoted, _ := json.Marshal(string(out))
+		out = append(quoted, '\n')
+	}
+
+	if *output == "" {
+		os.Stdout.Write(out)
+	} else if err := os.WriteFile(*output, out, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "echogy: %v\n", err)
+		return 1
+	}
+
+	signer, err := gossh.NewSignerFromSigner(key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "echogy: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%s %s\n", signer.PublicKey().Type(), gossh.FingerprintSHA256(signer.PublicKey()))
+	return 0
+}
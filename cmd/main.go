@@ -1,119 +1,86 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
+	"strings"
+)
 
-	"github.com/rs/zerolog"
-	"github.com/youkale/echogy"
-	"github.com/youkale/echogy/logger"
-	"github.com/youkale/echogy/pprof"
+// build information, injected by goreleaser through -ldflags
+var (
+	version   = "dev"
+	commit    = "none"
+	date      = "unknown"
+	builtBy   = "unknown"
+	treeState = "unknown"
 )
 
-var _conf = flag.String("c", "config.json", "config file, format json")
-var _pidFile = flag.String("pid", "", "pid file path (default: executable directory)")
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
 
-type Config struct {
-	LogLevel    string `json:"logLevel"`
-	LogFile     string `json:"logFile"` // Path to log file
-	EnablePProf bool   `json:"pprof"`
-	HttpAddr    string `json:"httpAddr"`
-	SSHAddr     string `json:"SSHAddr"`
-	Domain      string `json:"domain"`
-	PrivateKey  string `json:"privateKey"`
+var commands = []*command{
+	{name: "serve", summary: "start the echogy server", run: runServe},
+	{name: "connect", summary: "open a tunnel to a local service with auto-reconnect", run: runConnect},
+	{name: "keygen", summary: "generate an ssh host key", run: runKeygen},
+	{name: "check-config", summary: "parse and validate a config file", run: runCheckConfig},
+	{name: "status", summary: "query a running server through its admin api", run: runStatus},
+	{name: "version", summary: "print build information", run: runVersion},
 }
 
-func logLevel(level string) zerolog.Level {
-	switch level {
-	case "debug":
-		return zerolog.DebugLevel
-	case "info":
-		return zerolog.InfoLevel
-	case "warn":
-		return zerolog.WarnLevel
-	case "error":
-		return zerolog.ErrorLevel
-	case "fatal":
-		return zerolog.FatalLevel
-	case "panic":
-		return zerolog.PanicLevel
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: echogy <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-14s %s\n", c.name, c.summary)
 	}
-	return zerolog.WarnLevel
+	fmt.Fprintf(out, "\nRun 'echogy <command> --help' for details about a command.\n")
 }
 
-func setOSEnv() {
-	os.Setenv("COLORTERM", "truecolor")
-	os.Setenv("TERM", "xterm-256color")
-	os.Setenv("CLICOLOR", "1")
-	os.Setenv("CLICOLOR_FORCE", "1")
-	os.Setenv("FORCE_COLOR", "1")
-	os.Setenv("TERM_PROGRAM", "xterm")
+// newFlagSet creates a flag set for a subcommand whose --help prints the description and defaults
+func newFlagSet(name, usageLine, desc string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: echogy %s %s\n\n%s\n\nFlags:\n", name, usageLine, desc)
+		fs.PrintDefaults()
+	}
+	return fs
 }
 
-func main() {
-
-	flag.Parse()
-
-	// Create PID file
-	pidPath := *_pidFile
-	if pidPath == "" {
-		execPath, err := os.Executable()
-		if err != nil {
-			panic(err)
+// parseFlags parses args and returns the exit code to use when the command should stop
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return 0, false
 		}
-		pidPath = execPath + ".pid"
+		return 2, false
 	}
+	return 0, true
+}
 
-	setOSEnv()
+func main() {
+	args := os.Args[1:]
 
-	// Write PID to file
-	pid := os.Getpid()
-	if err := os.WriteFile(pidPath, []byte(fmt.Sprint(pid)), 0644); err != nil {
-		panic(err)
+	// keep the legacy flag-only invocation `echogy -c config.json` starting the server
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") && args[0] != "-h" && args[0] != "--help" {
+		os.Exit(runServe(args))
 	}
-	defer os.Remove(pidPath)
-
-	f, err := os.ReadFile(*_conf)
 
-	if nil != err {
-		panic(err)
+	name := args[0]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return
 	}
-	config := &Config{}
-	err = json.Unmarshal(f, config)
-	if nil != err {
-		panic(err)
-	}
-
-	logger.SetLogLevel(logLevel(config.LogLevel))
-
-	// Setup log file output if configured
-	if config.LogFile != "" {
-		if err := logger.AddFileOutput(config.LogFile); err != nil {
-			panic(fmt.Sprintf("Failed to setup log file: %v", err))
+	for _, c := range commands {
+		if c.name == name {
+			os.Exit(c.run(args[1:]))
 		}
-		logger.Info("Log file output enabled", logger.Fields{"path": config.LogFile})
-	}
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
-
-	if config.EnablePProf {
-		go func() {
-			pprof.Serve()
-		}()
 	}
-
-	go func() {
-		echogy.Serve(ctx, config.SSHAddr, config.HttpAddr, config.Domain, []byte(config.PrivateKey))
-	}()
-	<-c
-	logger.Warn("echogy will be shutdown", map[string]interface{}{})
-	cancelFunc()
+	fmt.Fprintf(os.Stderr, "echogy: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
 }
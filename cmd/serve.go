@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/youkale/echogy"
+	"github.com/youkale/echogy/logger"
+	"github.com/youkale/echogy/pprof"
+)
+
+func setOSEnv() {
+	os.Setenv("COLORTERM", "truecolor")
+	os.Setenv("TERM", "xterm-256color")
+	os.Setenv("CLICOLOR", "1")
+	os.Setenv("CLICOLOR_FORCE", "1")
+	os.Setenv("FORCE_COLOR", "1")
+	os.Setenv("TERM_PROGRAM", "xterm")
+}
+
+func runServe(args []string) int {
+	fs := newFlagSet("serve", "[-c config.json] [-pid file]",
+		"Start the ssh and http facade servers described by the config file.")
+	conf := fs.String("c", defaultConfigFile, "config file, format json")
+	pidFile := fs.String("pid", "", "pid file path (default: executable directory)")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	config, err := loadConfig(*conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "echogy: %v\n", err)
+		return 1
+	}
+	if errs := config.Validate(); len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "echogy: %s: %v\n", *conf, e)
+		}
+		return 1
+	}
+	// validated above
+	channelOpenTimeout, _ := config.channelOpenTimeout()
+	readHeaderTimeout, _ := positiveDuration(config.ReadHeaderTimeout)
+	idleTimeout, _ := positiveDuration(config.IdleTimeout)
+
+	// Create PID file
+	pidPath := *pidFile
+	if pidPath == "" {
+		execPath, err := os.Executable()
+		if err != nil {
+			panic(err)
+		}
+		pidPath = execPath + ".pid"
+	}
+
+	setOSEnv()
+
+	// Write PID to file
+	pid := os.Getpid()
+	if err := os.WriteFile(pidPath, []byte(fmt.Sprint(pid)), 0644); err != nil {
+		panic(err)
+	}
+	defer os.Remove(pidPath)
+
+	logger.SetLogLevel(logLevel(config.LogLevel))
+
+	// Setup log file output if configured
+	if config.LogFile != "" {
+		if err := logger.AddFileOutput(config.LogFile); err != nil {
+			panic(fmt.Sprintf("Failed to setup log file: %v", err))
+		}
+		logger.Info("Log file output enabled", logger.Fields{"path": config.LogFile})
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+
+	if config.EnablePProf {
+		go func() {
+			pprof.Serve()
+		}()
+	}
+
+	go func() {
+		echogy.Serve(ctx, &echogy.Options{
+			SSHAddr:            config.SSHAddr,
+			HttpAddr:           config.HttpAddr,
+			HttpsAddr:          config.HttpsAddr,
+			TLSCert:            config.TLSCert,
+			TLSKey:             config.TLSKey,
+			AdminAddr:          config.AdminAddr,
+			Domain:             config.Domain,
+			Domains:            config.domains(),
+			SubdomainDepth:     config.SubdomainDepth,
+			ErrorPages:         config.ErrorPages,
+			ChannelOpenTimeout: channelOpenTimeout,
+			ReadHeaderTimeout:  readHeaderTimeout,
+			MaxHeaderBytes:     config.MaxHeaderBytes,
+			MaxRequestLine:     config.MaxRequestLine,
+			IdleTimeout:        idleTimeout,
+			TunnelOptions:      config.TunnelOptions,
+			PrivateKey:         []byte(config.PrivateKey),
+			Version:            version,
+		})
+	}()
+	<-c
+	logger.Warn("echogy will be shutdown", map[string]interface{}{})
+	cancelFunc()
+	return 0
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/youkale/echogy"
+	"github.com/youkale/echogy/tui"
+)
+
+func runStatus(args []string) int {
+	fs := newFlagSet("status", "[-c config.json] [-addr host:port] [-json]",
+		"Query the admin api of a running server and print its tunnels.\n"+
+			"The admin address is taken from -addr, or from the adminAddr field of the config file.")
+	conf := fs.String("c", defaultConfigFile, "config file to read adminAddr from")
+	addr := fs.String("addr", "", "admin api address, overrides the config file")
+	asJSON := fs.Bool("json", false, "print the raw json status")
+	timeout := fs.Duration("timeout", 5*time.Second, "request timeout")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	adminAddr := *addr
+	if adminAddr == "" {
+		config, err := loadConfig(*conf)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "echogy: %v\n", err)
+			return 1
+		}
+		adminAddr = config.AdminAddr
+	}
+	if adminAddr == "" {
+		fmt.Fprintf(os.Stderr, "echogy: admin api is not configured, set adminAddr or pass -addr\n")
+		return 1
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	resp, err := client.Get(fmt.Sprintf("http://%s/api/status", adminAddr))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "echogy: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "echogy: admin api returned %s\n", resp.Status)
+		return 1
+	}
+
+	status := &echogy.ServerStatus{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		fmt.Fprintf(os.Stderr, "echogy: decode status: %v\n", err)
+		return 1
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(status)
+		return 0
+	}
+
+	fmt.Printf("version: %s\nuptime:  %s\ntunnels: %d\n", status.Version, status.Uptime, len(status.Tunnels))
+	if rejected := rejectedSummary(status.Rejected); rejected != "" {
+		fmt.Printf("rejected: %s\n", rejected)
+	}
+	if len(status.Tunnels) == 0 {
+		return 0
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ACCESS ID\tURL\tREMOTE\tCONNS\tIN\tOUT\tAGE")
+	for _, t := range status.Tunnels {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n", t.AccessId, t.URL, t.RemoteAddr, t.Connections,
+			tui.HumanBytes(t.BytesIn), tui.HumanBytes(t.BytesOut), time.Since(t.CreatedAt).Truncate(time.Second))
+	}
+	w.Flush()
+	return 0
+}
+
+// rejectedSummary lists the non-zero rejection counters, like "header_timeout=3 idle=12"
+func rejectedSummary(rejected map[string]int64) string {
+	reasons := make([]string, 0, len(rejected))
+	for reason, n := range rejected {
+		if n > 0 {
+			reasons = append(reasons, fmt.Sprintf("%s=%d", reason, n))
+		}
+	}
+	sort.Strings(reasons)
+	return strings.Join(reasons, " ")
+}
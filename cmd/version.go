@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func runVersion(args []string) int {
+	fs := newFlagSet("version", "", "Print build information.")
+	short := fs.Bool("short", false, "print the version number only")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+
+	if *short {
+		fmt.Println(version)
+		return 0
+	}
+	fmt.Printf("echogy %s\n", version)
+	fmt.Printf("  commit:     %s\n", commit)
+	fmt.Printf("  built at:   %s\n", date)
+	fmt.Printf("  built by:   %s\n", builtBy)
+	fmt.Printf("  tree state: %s\n", treeState)
+	fmt.Printf("  go:         %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return 0
+}
@@ -0,0 +1,324 @@
+package echogy
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/youkale/echogy/logger"
+	"github.com/youkale/echogy/tui"
+)
+
+// Tunnels opened with compress=on ask the local service for compressed responses, so fewer
+// bytes cross the uplink of the client. The edge passes them on to browsers accepting the
+// encoding, and decodes them, or encodes them again, for the others.
+
+// tunnelAcceptEncoding is the Accept-Encoding sent through compressing tunnels, best first
+const tunnelAcceptEncoding = "br, gzip"
+
+// a body recoded at the edge is cut off past these, a small bomb from the local service
+// mustn't keep the edge decoding and sending for long
+const (
+	maxRecodedBody     = 128 << 20 // decoded bytes
+	maxRecodeRatio     = 200       // decoded bytes per byte from the tunnel, once past minRecodeRatioBody
+	minRecodeRatioBody = 1 << 20
+)
+
+var errRecodeLimit = errors.New("the body decodes to more than the edge recodes")
+
+// acceptsEncoding reports whether an Accept-Encoding header value accepts encoding
+func acceptsEncoding(accept, encoding string) bool {
+	wildcard := false
+	for _, item := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(item, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		accepted := true
+		if q, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			weight, err := strconv.ParseFloat(q, 64)
+			accepted = err == nil && weight > 0
+		}
+		switch {
+		case name == encoding || encoding == "gzip" && name == "x-gzip":
+			return accepted
+		case name == "*":
+			wildcard = accepted
+		}
+	}
+	return wildcard
+}
+
+// clientEncoding picks the encoding a response is sent to the client with, empty for none
+func clientEncoding(accept string) string {
+	for _, encoding := range []string{"br", "gzip"} {
+		if acceptsEncoding(accept, encoding) {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// tunnelEncoding returns the encoding of a response from a compressing tunnel, it reports
+// false for an encoding the edge can't decode
+func tunnelEncoding(header http.Header) (string, bool) {
+	switch encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding"))); encoding {
+	case "", "identity":
+		return "", true
+	case "gzip", "x-gzip":
+		return "gzip", true
+	case "br":
+		return "br", true
+	}
+	return "", false
+}
+
+func newDecoder(encoding string, r io.Reader) (io.Reader, error) {
+	if encoding == "gzip" {
+		return gzip.NewReader(r)
+	}
+	return brotli.NewReader(r), nil
+}
+
+// flushWriter is an encoder, flushed after each write so streamed responses keep flowing
+type flushWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newEncoder(encoding string, w io.Writer) flushWriter {
+	if encoding == "gzip" {
+		return gzip.NewWriter(w)
+	}
+	return brotli.NewWriter(w)
+}
+
+// compressible reports whether the body of a response may be decoded and encoded again
+func compressible(req *http.Request, resp *http.Response) bool {
+	switch {
+	case req.Method == http.MethodHead, resp.Body == nil, resp.Body == http.NoBody:
+		return false
+	case resp.StatusCode == http.StatusSwitchingProtocols, resp.StatusCode == http.StatusNoContent,
+		resp.StatusCode == http.StatusNotModified, resp.StatusCode == http.StatusPartialContent:
+		return false
+	}
+	return true
+}
+
+// addVary adds a field to the Vary header unless it is listed
+func addVary(header http.Header, field string) {
+	for _, vary := range header.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			if name = strings.TrimSpace(name); name == "*" || strings.EqualFold(name, field) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", field)
+}
+
+// askCompressed asks the local service for a compressed response, range requests are left
+// alone since their ranges are of the encoded body
+func askCompressed(out *http.Request) {
+	if out.Header.Get("Range") == "" {
+		out.Header.Set("Accept-Encoding", tunnelAcceptEncoding)
+	}
+}
+
+// recode sends the response of a compressing tunnel the way the client accepts it and counts
+// the bytes saved, it returns nil when the response is left untouched. A response passed on as
+// it is only gets decoded, to be counted, when watched by a dashboard.
+func recode(req *http.Request, resp *http.Response, watched bool) *tui.Compression {
+	if !compressible(req, resp) {
+		return nil
+	}
+	encoding, known := tunnelEncoding(resp.Header)
+	if !known {
+		return nil
+	}
+	accept := req.Header.Get("Accept-Encoding")
+	addVary(resp.Header, "Accept-Encoding")
+	if encoding == "" || acceptsEncoding(accept, encoding) {
+		if !watched {
+			return nil
+		}
+		stats := tui.NewCompression(encoding, encoding)
+		resp.Body = newCountedBody(resp.Body, encoding, stats)
+		return stats
+	}
+
+	target := clientEncoding(accept)
+	stats := tui.NewCompression(encoding, target)
+	resp.Body = newRecodedBody(resp.Body, encoding, target, stats)
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	if target == "" {
+		resp.Header.Del("Content-Encoding")
+	} else {
+		resp.Header.Set("Content-Encoding", target)
+	}
+	// the client gets another representation than the one the service tagged
+	if etag := resp.Header.Get("Etag"); strings.HasPrefix(etag, `"`) {
+		resp.Header.Set("Etag", "W/"+etag)
+	}
+	return stats
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	io.Reader
+	count func(n int64)
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.Reader.Read(b)
+	c.count(int64(n))
+	return n, err
+}
+
+// codedBody is a response body fed by a goroutine decoding the body from the tunnel
+type codedBody struct {
+	io.Reader
+	wire io.Closer
+	stop func(error) // ends the goroutine early
+	done chan struct{}
+	once sync.Once
+}
+
+func (c *codedBody) Read(b []byte) (int, error) {
+	n, err := c.Reader.Read(b)
+	if err != nil {
+		c.finish(nil)
+	}
+	return n, err
+}
+
+// finish waits for the goroutine so the counts are final once the body is done
+func (c *codedBody) finish(err error) {
+	c.once.Do(func() {
+		if nil != err {
+			c.stop(err)
+		}
+		<-c.done
+	})
+}
+
+func (c *codedBody) Close() error {
+	err := c.wire.Close()
+	c.finish(io.ErrClosedPipe)
+	return err
+}
+
+// newCountedBody passes a body on as it is, a goroutine decodes a copy to count its size
+func newCountedBody(wire io.ReadCloser, encoding string, stats *tui.Compression) io.ReadCloser {
+	counted := &countingReader{Reader: wire, count: func(n int64) { stats.Count(n, 0) }}
+	if encoding == "" {
+		counted.count = func(n int64) { stats.Count(n, n) }
+		return &teeBody{Reader: counted, Closer: wire}
+	}
+	pr, pw := io.Pipe()
+	body := &codedBody{
+		Reader: io.TeeReader(counted, pw),
+		wire:   wire,
+		stop:   func(err error) { pr.CloseWithError(err) },
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(body.done)
+		// what can't be decoded is still read, the body on its way to the client waits for it
+		defer io.Copy(io.Discard, pr)
+		decoder, err := newDecoder(encoding, pr)
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, &countingReader{Reader: decoder, count: func(n int64) { stats.Count(0, n) }})
+	}()
+	// the tee writes once more at the end of the body, closing the pipe ends the decoding
+	body.Reader = &eofCloser{Reader: body.Reader, close: func() { pw.Close() }}
+	return body
+}
+
+// eofCloser runs close when its reader is done
+type eofCloser struct {
+	io.Reader
+	close func()
+	once  sync.Once
+}
+
+func (e *eofCloser) Read(b []byte) (int, error) {
+	n, err := e.Reader.Read(b)
+	if err != nil {
+		e.once.Do(e.close)
+	}
+	return n, err
+}
+
+// newRecodedBody decodes a body and encodes it again with target, or sends it decoded when
+// target is empty
+func newRecodedBody(wire io.ReadCloser, encoding, target string, stats *tui.Compression) io.ReadCloser {
+	pr, pw := io.Pipe()
+	body := &codedBody{
+		Reader: pr,
+		wire:   wire,
+		stop:   func(err error) { pr.CloseWithError(err) },
+		done:   make(chan struct{}),
+	}
+	go func() {
+		defer close(body.done)
+		err := recodeTo(pw, wire, encoding, target, stats)
+		if errors.Is(err, errRecodeLimit) {
+			wireSize, decoded := stats.Sizes()
+			logger.Warn("recoding aborted", map[string]interface{}{
+				"module":  "compress",
+				"wire":    wireSize,
+				"decoded": decoded,
+			})
+		}
+		pw.CloseWithError(err)
+	}()
+	return body
+}
+
+func recodeTo(w io.Writer, wire io.Reader, encoding, target string, stats *tui.Compression) error {
+	decoder, err := newDecoder(encoding, &countingReader{Reader: wire, count: func(n int64) { stats.Count(n, 0) }})
+	if err != nil {
+		return err
+	}
+	var encoder flushWriter
+	if target != "" {
+		encoder = newEncoder(target, w)
+		w = encoder
+	}
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := decoder.Read(buf)
+		stats.Count(0, int64(n))
+		if wireSize, decoded := stats.Sizes(); decoded > maxRecodedBody ||
+			decoded > minRecodeRatioBody && decoded > wireSize*maxRecodeRatio {
+			return errRecodeLimit
+		}
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if nil != encoder {
+				if werr := encoder.Flush(); werr != nil {
+					return werr
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if nil != encoder {
+		return encoder.Close()
+	}
+	return nil
+}
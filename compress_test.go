@@ -0,0 +1,176 @@
+package echogy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		accept   string
+		encoding string
+		want     bool
+	}{
+		{accept: "gzip, deflate, br", encoding: "br", want: true},
+		{accept: "gzip", encoding: "br", want: false},
+		{accept: "x-gzip", encoding: "gzip", want: true},
+		{accept: "br;q=0, gzip;q=0.5", encoding: "br", want: false},
+		{accept: "br;q=0, gzip;q=0.5", encoding: "gzip", want: true},
+		{accept: "*", encoding: "br", want: true},
+		{accept: "*, br;q=0", encoding: "br", want: false},
+		{accept: "", encoding: "gzip", want: false},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.accept, tt.encoding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tt.accept, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func encode(t *testing.T, encoding, s string) []byte {
+	b := &bytes.Buffer{}
+	w := newEncoder(encoding, b)
+	io.WriteString(w, s)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func decode(t *testing.T, encoding string, data []byte) string {
+	var r io.Reader = bytes.NewReader(data)
+	switch encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = gz
+	case "br":
+		r = brotli.NewReader(r)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestRecode(t *testing.T) {
+	page := strings.Repeat("<p>hello echogy</p>\n", 500)
+	tests := []struct {
+		name       string
+		tunnel     string // encoding sent by the service
+		accept     string // Accept-Encoding of the client
+		wantClient string
+	}{
+		{name: "passed on", tunnel: "br", accept: "gzip, br", wantClient: "br"},
+		{name: "decoded", tunnel: "gzip", accept: "", wantClient: ""},
+		{name: "encoded again", tunnel: "br", accept: "gzip", wantClient: "gzip"},
+		{name: "not compressed", tunnel: "", accept: "gzip", wantClient: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wire := []byte(page)
+			header := http.Header{"Etag": {`"v1"`}}
+			if tt.tunnel != "" {
+				wire = encode(t, tt.tunnel, page)
+				header.Set("Content-Encoding", tt.tunnel)
+			}
+			req, _ := http.NewRequest(http.MethodGet, "http://a.webs.sh/", nil)
+			req.Header.Set("Accept-Encoding", tt.accept)
+			resp := &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(bytes.NewReader(wire)),
+				ContentLength: int64(len(wire))}
+
+			stats := recode(req, resp, true)
+			got, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if encoding := resp.Header.Get("Content-Encoding"); encoding != tt.wantClient {
+				t.Fatalf("Content-Encoding = %q, want %q", encoding, tt.wantClient)
+			}
+			if body := decode(t, tt.wantClient, got); body != page {
+				t.Errorf("body decodes to %d bytes, want %d", len(body), len(page))
+			}
+			if stats.Tunnel != tt.tunnel || stats.Client != tt.wantClient {
+				t.Errorf("stats = %s → %s", stats.Tunnel, stats.Client)
+			}
+			if wireSize, decoded := stats.Sizes(); wireSize != int64(len(wire)) || decoded != int64(len(page)) {
+				t.Errorf("sizes = %d, %d, want %d, %d", wireSize, decoded, len(wire), len(page))
+			}
+			if recoded := tt.tunnel != tt.wantClient; recoded != (resp.Header.Get("Etag") == `W/"v1"`) {
+				t.Errorf("Etag = %q", resp.Header.Get("Etag"))
+			}
+			if resp.Header.Get("Vary") != "Accept-Encoding" {
+				t.Errorf("Vary = %q", resp.Header.Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestRecodeLimits(t *testing.T) {
+	bomb := &bytes.Buffer{}
+	w := gzip.NewWriter(bomb)
+	w.Write(make([]byte, 2<<20))
+	w.Close()
+	req, _ := http.NewRequest(http.MethodGet, "http://a.webs.sh/", nil)
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Encoding": {"gzip"}},
+		Body: io.NopCloser(bomb)}
+
+	recode(req, resp, false)
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != errRecodeLimit {
+		t.Errorf("recoding a bomb of %d bytes = %d bytes, %v, want %v", bomb.Len(), n, err, errRecodeLimit)
+	}
+
+	// unwatched, a body passed on as it is isn't decoded
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp = &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Encoding": {"gzip"}},
+		Body: io.NopCloser(strings.NewReader("not gzip at all"))}
+	if stats := recode(req, resp, false); nil != stats {
+		t.Errorf("unwatched response counted")
+	}
+	if body, _ := io.ReadAll(resp.Body); string(body) != "not gzip at all" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestFacadeCompression(t *testing.T) {
+	page := strings.Repeat("console.log('echogy');\n", 200)
+	fwd, closeTunnel := backendTunnel("dev", "", false, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != tunnelAcceptEncoding {
+			t.Errorf("Accept-Encoding toward the tunnel = %q", r.Header.Get("Accept-Encoding"))
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(encode(t, "gzip", page))
+	})
+	defer closeTunnel()
+	fwd.opts.compress = true
+
+	client := &http.Client{Transport: &http.Transport{
+		DisableCompression: true,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			c, s := net.Pipe()
+			go handleConnection(s, newHostRouter(1, "webs.sh"), func(string, string, *hijackConn) bool { return false })
+			return c, nil
+		},
+	}}
+	// a client without compression gets the page decoded
+	resp, err := client.Get("http://dev.webs.sh/app.js")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "" || string(body) != page {
+		t.Errorf("response = %q, %d bytes, want the page decoded", resp.Header.Get("Content-Encoding"), len(body))
+	}
+}
@@ -1,11 +1,15 @@
 package echogy
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/gliderlabs/ssh"
 	gossh "golang.org/x/crypto/ssh"
 	"io"
 	"net"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -34,10 +38,11 @@ func (c *bufferedConn) Read(b []byte) (n int, err error) {
 	return c.reader.Read(b)
 }
 
+// toBufferedConn replays what was buffered, then reads from conn directly, the rest of the
+// connection isn't buffered
 func (b *bufferedReader) toBufferedConn(conn net.Conn) net.Conn {
-	b.Reset()
 	return &bufferedConn{
-		reader: io.MultiReader(b, conn),
+		reader: io.MultiReader(bytes.NewReader(b.buffer), conn),
 		Conn:   conn,
 	}
 }
@@ -107,13 +112,126 @@ func (b *bufferedReader) Position() int {
 	return b.position
 }
 
+// countingConn adds the number of bytes read from a conn to each of its counters,
+// and those written to it to its write counters
+type countingConn struct {
+	net.Conn
+	counters []*atomic.Int64
+	writes   []*atomic.Int64
+}
+
+func countReads(conn net.Conn, counters ...*atomic.Int64) *countingConn {
+	return &countingConn{Conn: conn, counters: counters}
+}
+
+// countWrites also counts the bytes written to the conn
+func (c *countingConn) countWrites(counters ...*atomic.Int64) *countingConn {
+	c.writes = counters
+	return c
+}
+
+func (c *countingConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	for _, counter := range c.counters {
+		counter.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	for _, counter := range c.writes {
+		counter.Add(int64(n))
+	}
+	return n, err
+}
+
+// deadline is closed once its time passes, like the deadlines of net.Pipe
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+	armed  bool
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+func closedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// set arms the deadline, the zero time disarms it
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if nil != d.timer && !d.timer.Stop() {
+		<-d.cancel // the timer fired, wait for it to close cancel
+	}
+	d.timer = nil
+	d.armed = !t.IsZero()
+	closed := closedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel closed when the deadline passes, and whether one is set
+func (d *deadline) wait() (<-chan struct{}, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel, d.armed
+}
+
+type readResult struct {
+	b   []byte
+	err error
+}
+
+// wrappedConn is a forwarded-tcpip channel as a net.Conn. Channels have no deadlines, so a read
+// or write with one set runs in the background and is given up on when it passes, an abandoned
+// read hands its bytes to the next one.
 type wrappedConn struct {
 	session ssh.Session
 	gossh.Channel
+
+	readDeadline, writeDeadline *deadline
+
+	readMu  sync.Mutex
+	reading chan readResult // the read still running in the background
+	pending readResult      // what it read and wasn't returned yet
+
+	writing chan struct{} // one write at a time, a given up write may still be running
 }
 
 func wrapChannelConn(session ssh.Session, channel gossh.Channel) *wrappedConn {
-	return &wrappedConn{session: session, Channel: channel}
+	return &wrappedConn{
+		session:       session,
+		Channel:       channel,
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		writing:       make(chan struct{}, 1),
+	}
 }
 
 func (w *wrappedConn) bufferedReader() *bufferedReader {
@@ -128,15 +246,92 @@ func (w *wrappedConn) RemoteAddr() net.Addr {
 	return w.session.RemoteAddr()
 }
 
+func (w *wrappedConn) Read(b []byte) (int, error) {
+	w.readMu.Lock()
+	defer w.readMu.Unlock()
+	if len(w.pending.b) > 0 || nil != w.pending.err {
+		return w.takePending(b)
+	}
+	expired, set := w.readDeadline.wait()
+	if closedChan(expired) {
+		return 0, os.ErrDeadlineExceeded
+	}
+	if !set && nil == w.reading {
+		return w.Channel.Read(b)
+	}
+	if nil == w.reading {
+		reading := make(chan readResult, 1)
+		buf := make([]byte, len(b))
+		go func() {
+			n, err := w.Channel.Read(buf)
+			reading <- readResult{b: buf[:n], err: err}
+		}()
+		w.reading = reading
+	}
+	select {
+	case w.pending = <-w.reading:
+		w.reading = nil
+		return w.takePending(b)
+	case <-expired:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+func (w *wrappedConn) takePending(b []byte) (int, error) {
+	n := copy(b, w.pending.b)
+	w.pending.b = w.pending.b[n:]
+	if len(w.pending.b) > 0 {
+		return n, nil
+	}
+	err := w.pending.err
+	w.pending.err = nil
+	return n, err
+}
+
+func (w *wrappedConn) Write(b []byte) (int, error) {
+	expired, set := w.writeDeadline.wait()
+	select {
+	case w.writing <- struct{}{}:
+	case <-expired:
+		return 0, os.ErrDeadlineExceeded
+	}
+	if !set {
+		defer func() { <-w.writing }()
+		return w.Channel.Write(b)
+	}
+	type writeResult struct {
+		n   int
+		err error
+	}
+	done := make(chan writeResult, 1)
+	// the caller may reuse b once the deadline gave up on the write
+	buf := append([]byte(nil), b...)
+	go func() {
+		defer func() { <-w.writing }()
+		n, err := w.Channel.Write(buf)
+		done <- writeResult{n, err}
+	}()
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-expired:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
 func (w *wrappedConn) SetDeadline(t time.Time) error {
+	w.readDeadline.set(t)
+	w.writeDeadline.set(t)
 	return nil
 }
 
 func (w *wrappedConn) SetReadDeadline(t time.Time) error {
+	w.readDeadline.set(t)
 	return nil
 }
 
 func (w *wrappedConn) SetWriteDeadline(t time.Time) error {
+	w.writeDeadline.set(t)
 	return nil
 }
 
@@ -0,0 +1,103 @@
+package echogy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestCountReads(t *testing.T) {
+	client, server := net.Pipe()
+	var conn, total atomic.Int64
+	total.Store(100)
+	counted := countReads(server, &conn, &total)
+
+	go func() {
+		client.Write([]byte("GET / HTTP/1.1\r\n"))
+		client.Write([]byte("not http at all"))
+		client.Close()
+	}()
+	data, err := io.ReadAll(counted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conn.Load() != int64(len(data)) || conn.Load() != 31 {
+		t.Errorf("conn counter = %d, read %d bytes", conn.Load(), len(data))
+	}
+	if total.Load() != 131 {
+		t.Errorf("total counter = %d, want 131", total.Load())
+	}
+}
+
+// pipeChannel is a channel made of one end of a pipe
+type pipeChannel struct {
+	gossh.Channel
+	net.Conn
+}
+
+func (c *pipeChannel) Read(b []byte) (int, error)  { return c.Conn.Read(b) }
+func (c *pipeChannel) Write(b []byte) (int, error) { return c.Conn.Write(b) }
+func (c *pipeChannel) Close() error                { return c.Conn.Close() }
+
+func TestBufferedConnStopsBuffering(t *testing.T) {
+	client, server := net.Pipe()
+	head := "POST /upload HTTP/1.1\r\nHost: a.webs.sh\r\nContent-Length: 1048576\r\n\r\n"
+	body := strings.Repeat("x", 1<<20)
+	go func() {
+		io.WriteString(client, head)
+		io.WriteString(client, body)
+		client.Close()
+	}()
+
+	reader := newBufferedReader(server)
+	if _, err := readRequestHead(server, reader); err != nil {
+		t.Fatal(err)
+	}
+	buffered := reader.Len()
+	data, err := io.ReadAll(reader.toBufferedConn(server))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != head+body {
+		t.Fatalf("read %d bytes, want the head and body replayed, %d bytes", len(data), len(head)+len(body))
+	}
+	if reader.Len() != buffered {
+		t.Errorf("buffer grew from %d to %d bytes past the head", buffered, reader.Len())
+	}
+}
+
+func TestWrappedConnDeadline(t *testing.T) {
+	local, tunnel := net.Pipe()
+	defer local.Close()
+	conn := wrapChannelConn(nil, &pipeChannel{Conn: tunnel})
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	b := make([]byte, 16)
+	if _, err := conn.Read(b); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read() error = %v, want deadline exceeded", err)
+	}
+	conn.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := conn.Write([]byte("stalled")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Write() error = %v, want deadline exceeded", err)
+	}
+
+	// the abandoned read gets what comes next, after the stalled write went through
+	conn.SetDeadline(time.Time{})
+	got := make([]byte, 7)
+	if _, err := io.ReadFull(local, got); err != nil || string(got) != "stalled" {
+		t.Fatalf("local read %q, %v", got, err)
+	}
+	go local.Write([]byte("hello"))
+	n, err := conn.Read(b)
+	if err != nil || string(b[:n]) != "hello" {
+		t.Errorf("Read() = %q, %v, want hello", b[:n], err)
+	}
+}
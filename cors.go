@@ -0,0 +1,167 @@
+package echogy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/youkale/echogy/tui"
+	"golang.org/x/net/http/httpguts"
+)
+
+// corsMethods are the methods allowed cross-origin unless the cors-methods option lists them
+var corsMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// corsMaxAge is how long browsers may keep an answered preflight, in seconds
+const corsMaxAge = "600"
+
+// corsPolicy lets pages of other origins call the tunnel, set with the cors options. The edge
+// answers the preflights and adds the Access-Control-Allow-* headers to the responses.
+type corsPolicy struct {
+	enabled     bool
+	origins     []string // allowed origins, any origin when empty
+	credentials bool     // listed origins may send cookies
+	methods     []string // allowed methods, corsMethods when empty
+	headers     []string // allowed request headers, those the preflight asks for when empty
+}
+
+// parseList parses a comma separated option value, each item checked by valid
+func parseList(key, value string, valid func(string) bool) ([]string, error) {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if !valid(item) {
+			return nil, fmt.Errorf("option %s: %q is not valid", key, item)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// validOrigin accepts an origin as browsers send it, like https://app.example.com:8443
+func validOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && u.User == nil && origin == u.Scheme+"://"+u.Host
+}
+
+// parse sets the policy from a cors option
+func (c *corsPolicy) parse(key, value string) (err error) {
+	switch key {
+	case "cors":
+		c.enabled, err = parseBool(key, value)
+	case "cors-origins":
+		if value == "*" {
+			c.origins = nil
+			return nil
+		}
+		c.origins, err = parseList(key, strings.ToLower(value), validOrigin)
+	case "cors-credentials":
+		c.credentials, err = parseBool(key, value)
+	case "cors-methods":
+		c.methods, err = parseList(key, strings.ToUpper(value), httpguts.ValidHeaderFieldName)
+	case "cors-headers":
+		c.headers, err = parseList(key, value, httpguts.ValidHeaderFieldName)
+	}
+	return err
+}
+
+// configured reports whether any of the cors-* options was given
+func (c *corsPolicy) configured() bool {
+	return len(c.origins) > 0 || c.credentials || len(c.methods) > 0 || len(c.headers) > 0
+}
+
+// allowOrigin sets the headers letting origin read the response, it reports false for an
+// origin that isn't allowed
+func (c *corsPolicy) allowOrigin(header http.Header, origin string) bool {
+	if len(c.origins) == 0 {
+		header.Set("Access-Control-Allow-Origin", "*")
+		return true
+	}
+	header.Add("Vary", "Origin")
+	if !slices.Contains(c.origins, strings.ToLower(origin)) {
+		return false
+	}
+	header.Set("Access-Control-Allow-Origin", origin)
+	if c.credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// isPreflight reports whether a request is a CORS preflight the edge answers
+func (c *corsPolicy) isPreflight(r *http.Request) bool {
+	return c.enabled && r.Method == http.MethodOptions && r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// preflight answers a preflight, an origin that isn't allowed gets no Access-Control-Allow-*
+// headers, which the browser takes as a refusal
+func (c *corsPolicy) preflight(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	if c.allowOrigin(header, r.Header.Get("Origin")) {
+		methods := c.methods
+		if len(methods) == 0 {
+			methods = corsMethods
+		}
+		header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(c.headers) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(c.headers, ", "))
+		} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+		header.Set("Access-Control-Max-Age", corsMaxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decorate adds the CORS headers to a response from the tunnel. With listed origins they
+// replace those the local service sent, otherwise a response the service already allowed
+// cross-origin is left as it is.
+func (c *corsPolicy) decorate(req *http.Request, resp *http.Response) {
+	origin := req.Header.Get("Origin")
+	if !c.enabled || origin == "" {
+		return
+	}
+	if len(c.origins) == 0 {
+		if resp.Header.Get("Access-Control-Allow-Origin") == "" {
+			c.allowOrigin(resp.Header, origin)
+		}
+		return
+	}
+	for name := range resp.Header {
+		if strings.HasPrefix(name, "Access-Control-Allow-") {
+			resp.Header.Del(name)
+		}
+	}
+	c.allowOrigin(resp.Header, origin)
+}
+
+// answerPreflight answers a preflight for the tunnel at the edge and shows it on the dashboard
+func (fwd *forwarder) answerPreflight(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	fwd.opts.cors.preflight(w, r)
+	if nil == fwd.pty {
+		return
+	}
+	fwd.pty.Notify(&tui.Exchange{
+		Response: &http.Response{
+			Status:     "204 No Content",
+			StatusCode: http.StatusNoContent,
+			Proto:      r.Proto,
+			ProtoMajor: r.ProtoMajor,
+			ProtoMinor: r.ProtoMinor,
+			Header:     w.Header().Clone(),
+			Request:    r,
+		},
+		Request:   r,
+		UseTime:   time.Since(startTime).Milliseconds(),
+		StartTime: startTime,
+		Edge:      "CORS preflight",
+	})
+}
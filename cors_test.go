@@ -0,0 +1,116 @@
+package echogy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCorsPolicyParse(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr bool
+	}{
+		{args: []string{"cors=on"}},
+		{args: []string{"cors=on", "cors-origins=https://app.example.com,http://localhost:5173"}},
+		{args: []string{"cors=on", "cors-origins=*", "cors-methods=get,post", "cors-headers=Content-Type,X-Token"}},
+		{args: []string{"cors=on", "cors-origins=https://app.example.com", "cors-credentials=on"}},
+		{args: []string{"cors-origins=https://app.example.com"}, wantErr: true},
+		{args: []string{"cors=on", "cors-credentials=on"}, wantErr: true},
+		{args: []string{"cors=on", "cors-origins=*", "cors-credentials=on"}, wantErr: true},
+		{args: []string{"cors=on", "cors-origins=app.example.com"}, wantErr: true},
+		{args: []string{"cors=on", "cors-origins=https://app.example.com/"}, wantErr: true},
+		{args: []string{"cors=on", "cors-methods=GET,"}, wantErr: true},
+		{args: []string{"cors=on", "cors-headers=Bad Header"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		opts, err := parseTunnelOptions(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTunnelOptions(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !opts.perRequest() {
+			t.Errorf("parseTunnelOptions(%q) leaves the connections piped", tt.args)
+		}
+	}
+}
+
+func TestFacadeCors(t *testing.T) {
+	var reached atomic.Int32
+	fwd, closeTunnel := backendTunnel("api", "", false, func(w http.ResponseWriter, r *http.Request) {
+		reached.Add(1)
+		w.Header().Set("Access-Control-Allow-Origin", "https://service.example.com")
+		io.WriteString(w, "ok")
+	})
+	defer closeTunnel()
+	for _, arg := range [][2]string{{"cors", "on"}, {"cors-origins", "https://app.example.com"}, {"cors-credentials", "on"}, {"cors-methods", "GET,PUT"}} {
+		if err := fwd.opts.cors.parse(arg[0], arg[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			c, s := net.Pipe()
+			go handleConnection(s, newHostRouter(1, "webs.sh"), func(string, string, *hijackConn) bool { return false })
+			return c, nil
+		},
+	}}
+	do := func(method, origin string, header ...string) *http.Response {
+		req, _ := http.NewRequest(method, "http://api.webs.sh/users", nil)
+		req.Header.Set("Origin", origin)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := do(http.MethodOptions, "https://app.example.com",
+		"Access-Control-Request-Method", "PUT", "Access-Control-Request-Headers", "content-type")
+	if resp.StatusCode != http.StatusNoContent || reached.Load() != 0 {
+		t.Fatalf("preflight = %d, reached the service %d times", resp.StatusCode, reached.Load())
+	}
+	if resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		resp.Header.Get("Access-Control-Allow-Methods") != "GET, PUT" ||
+		resp.Header.Get("Access-Control-Allow-Headers") != "content-type" {
+		t.Errorf("preflight headers = %v", resp.Header)
+	}
+
+	resp = do(http.MethodOptions, "https://evil.example.com", "Access-Control-Request-Method", "PUT")
+	if resp.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("preflight of an origin not allowed = %v", resp.Header)
+	}
+
+	resp = do(http.MethodGet, "https://app.example.com")
+	if reached.Load() != 1 || resp.Header.Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		resp.Header.Get("Access-Control-Allow-Credentials") != "true" {
+		t.Errorf("response headers = %v", resp.Header)
+	}
+
+	// an OPTIONS request that isn't a preflight goes to the service
+	do(http.MethodOptions, "https://app.example.com")
+	if reached.Load() != 2 {
+		t.Errorf("a plain OPTIONS request didn't reach the service")
+	}
+
+	fwd.opts.cors.credentials = false
+	resp = do(http.MethodGet, "https://app.example.com")
+	if resp.Header.Get("Access-Control-Allow-Credentials") != "" {
+		t.Errorf("credentials allowed without cors-credentials: %v", resp.Header)
+	}
+
+	// allowing any origin, the service keeps the say on its own responses
+	fwd.opts.cors.origins = nil
+	resp = do(http.MethodGet, "https://app.example.com")
+	if resp.Header.Get("Access-Control-Allow-Origin") != "https://service.example.com" {
+		t.Errorf("response headers of the service replaced: %v", resp.Header)
+	}
+}
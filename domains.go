@@ -0,0 +1,351 @@
+package echogy
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/youkale/echogy/logger"
+)
+
+const (
+	// verificationRecord prefixes the host for the TXT record proving ownership of a custom domain
+	verificationRecord = "_echogy."
+	// verificationPrefix starts the value of the TXT record, the token follows it
+	verificationPrefix = "echogy-verification="
+	// verificationPath is where the token is served for the HTTP check
+	verificationPath = "/.well-known/echogy-verification.txt"
+
+	verificationTimeout = 10 * time.Second
+	// verifiedFor is how long a passed check lets sessions with the same key claim the domain
+	verifiedFor = time.Hour
+)
+
+// Domain is an apex domain tunnels are served under. Tunnel names are one namespace across
+// the domains of a server, dedicated ones included: a name taken on one domain can't be
+// opened on another, the tunnel holding it serves the domains it landed on
+type Domain struct {
+	Name    string
+	TLSCert string // certificate and key files, the default ones when empty
+	TLSKey  string
+	// SSHAddr is an ssh listener whose tunnels land on this domain only
+	SSHAddr string
+	// SSHUserSuffix picks this domain alone for ssh users ending with it, e.g. "+staging"
+	SSHUserSuffix string
+}
+
+// inUse tells a session why the name it asked for is taken, landing are the domains it would land on
+func inUse(id string, holder *forwarder, landing []string) string {
+	for _, domain := range holder.domains {
+		if slices.Contains(landing, domain) {
+			return fmt.Sprintf("tunnel name %s is already in use", id)
+		}
+	}
+	return fmt.Sprintf("tunnel name %s is already in use on %s, tunnel names are shared by every domain of the server",
+		id, strings.Join(holder.domains, ", "))
+}
+
+// dedicated reports whether tunnels land on the domain only when picked
+func (d *Domain) dedicated() bool {
+	return d.SSHAddr != "" || d.SSHUserSuffix != ""
+}
+
+// landingDomains returns the domains a tunnel of an ssh user lands on, the one its
+// name picks, otherwise every domain that isn't dedicated
+func landingDomains(domains []Domain, user string) []string {
+	for _, domain := range domains {
+		if domain.SSHUserSuffix != "" && strings.HasSuffix(user, domain.SSHUserSuffix) {
+			return []string{domain.Name}
+		}
+	}
+	var names []string
+	for _, domain := range domains {
+		if !domain.dedicated() {
+			names = append(names, domain.Name)
+		}
+	}
+	return names
+}
+
+// customDomains maps the full host names of verified custom domains to their tunnels
+var customDomains sync.Map
+
+// hostRouter finds the tunnel a request host points to
+type hostRouter struct {
+	domains []string // tunnels are served as subdomains of these
+	depth   int      // labels allowed under a domain, the tunnel is the one next to the domain
+}
+
+func newHostRouter(depth int, domains ...string) *hostRouter {
+	return &hostRouter{domains: domains, depth: max(depth, 1)}
+}
+
+// normalizeHost strips the port and lowercases a request host
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// match returns the longest domain host is a subdomain of
+func (r *hostRouter) match(host string) string {
+	matched := ""
+	for _, domain := range r.domains {
+		if strings.HasSuffix(host, "."+domain) && len(domain) > len(matched) {
+			matched = domain
+		}
+	}
+	return matched
+}
+
+// route looks a host up in the custom domains first, then takes the label next to the
+// domain it is under, hosts outside the domains or nested too deep aren't for this server.
+// The domain is empty for a custom domain.
+func (r *hostRouter) route(host string) (id, domain string, ok bool) {
+	host = normalizeHost(host)
+	if value, found := customDomains.Load(host); found {
+		return value.(string), "", true
+	}
+	domain = r.match(host)
+	if domain == "" {
+		return "", "", false
+	}
+	labels := strings.Split(strings.TrimSuffix(host, "."+domain), ".")
+	if len(labels) > r.depth || slices.Contains(labels, "") {
+		return "", "", false
+	}
+	return labels[len(labels)-1], domain, true
+}
+
+// lookupTunnel finds a tunnel by name, as long as it lands on the domain, a pooled name
+// gives the member picked for the connection
+func lookupTunnel(id, domain string) (*forwarder, bool) {
+	value, found := sessionHub.Load(id)
+	if !found {
+		return nil, false
+	}
+	fwd := value.(*forwarder)
+	if nil != fwd.pool {
+		fwd = fwd.pool.pick(domain, false)
+		return fwd, nil != fwd
+	}
+	if domain != "" && !slices.Contains(fwd.domains, domain) {
+		return nil, false
+	}
+	return fwd, true
+}
+
+// tlsConfig serves each domain with its own certificate, or the default one
+func tlsConfig(certFile, keyFile string, router *hostRouter, domains []Domain) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	certs := map[string]*tls.Certificate{}
+	for _, domain := range domains {
+		if domain.TLSCert == "" {
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(domain.TLSCert, domain.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", domain.Name, err)
+		}
+		certs[domain.Name] = &cert
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := normalizeHost(hello.ServerName)
+			if cert, ok := certs[name]; ok {
+				return cert, nil
+			}
+			// nil falls back to the default certificate
+			return certs[router.match(name)], nil
+		},
+	}, nil
+}
+
+// Resolver looks up the TXT records of a name, *net.Resolver is one
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// domainVerifier checks that whoever opens a tunnel with a custom domain controls it,
+// with a TXT record or a token served over HTTP at the domain
+type domainVerifier struct {
+	resolver Resolver
+	client   *http.Client
+	secret   []byte
+
+	verified sync.Map // tokens that passed until they expire, the HTTP check can't pass again once the domain points here
+}
+
+func newDomainVerifier(resolver Resolver, secret []byte) *domainVerifier {
+	if nil == resolver {
+		resolver = net.DefaultResolver
+	}
+	mac := hmac.New(sha256.New, []byte("echogy custom domain"))
+	mac.Write(secret)
+	return &domainVerifier{
+		resolver: resolver,
+		client: &http.Client{
+			Timeout: verificationTimeout,
+			// the host is whatever a session asks for, it must not reach into the network of the server
+			Transport: &http.Transport{
+				DialContext:       publicDialer().DialContext,
+				DisableKeepAlives: true,
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		secret: mac.Sum(nil),
+	}
+}
+
+// token is what proves the host is meant for the sessions opened with key, it doesn't change
+// across restarts. Tunnel names are first come, the key is what the owner alone knows.
+func (v *domainVerifier) token(host, key string) string {
+	mac := hmac.New(sha256.New, v.secret)
+	fmt.Fprintf(mac, "%s\n%s", host, key)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// verify checks the TXT record first, then the HTTP token
+func (v *domainVerifier) verify(ctx context.Context, host, key string) error {
+	token := v.token(host, key)
+	if until, ok := v.verified.Load(token); ok && time.Now().Before(until.(time.Time)) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, verificationTimeout)
+	defer cancel()
+	txtErr := v.verifyTXT(ctx, host, token)
+	if txtErr == nil {
+		v.verified.Store(token, time.Now().Add(verifiedFor))
+		return nil
+	}
+	httpErr := v.verifyHTTP(ctx, host, token)
+	if httpErr == nil {
+		v.verified.Store(token, time.Now().Add(verifiedFor))
+		return nil
+	}
+	return errors.Join(txtErr, httpErr)
+}
+
+// forget drops a passed check once the last session serving the host left
+func (v *domainVerifier) forget(host, key string) {
+	v.verified.Delete(v.token(host, key))
+}
+
+func (v *domainVerifier) verifyTXT(ctx context.Context, host, token string) error {
+	records, err := v.resolver.LookupTXT(ctx, verificationRecord+host)
+	if err != nil {
+		return fmt.Errorf("TXT %s%s: %w", verificationRecord, host, err)
+	}
+	for _, record := range records {
+		if strings.TrimSpace(record) == verificationPrefix+token {
+			return nil
+		}
+	}
+	return fmt.Errorf("TXT %s%s: no %s record for this tunnel", verificationRecord, host, verificationPrefix)
+}
+
+// verifyHTTP fetches the token from host, what went wrong is logged but not told to the
+// session, the server mustn't serve as a probe of the hosts it can reach
+func (v *domainVerifier) verifyHTTP(ctx context.Context, host, token string) error {
+	url := "http://" + host + verificationPath
+	failed := fmt.Errorf("GET %s: the token of this tunnel isn't served there", url)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return failed
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		logger.Debug("verify custom domain", map[string]interface{}{
+			"module": "serve",
+			"domain": host,
+			"error":  err.Error(),
+		})
+		return failed
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != token {
+		logger.Debug("verify custom domain", map[string]interface{}{
+			"module": "serve",
+			"domain": host,
+			"status": resp.Status,
+		})
+		return failed
+	}
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, not reachable from the internet either
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// publicAddr reports whether an address is reachable from the internet
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// publicDialer connects to public addresses only. The address is checked once resolved, right
+// before connecting, so a name resolving elsewhere on a second lookup can't get around it.
+func publicDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout: verificationTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if addr, err := netip.ParseAddr(host); err != nil || !publicAddr(addr) {
+				return fmt.Errorf("%s is not a public address", host)
+			}
+			return nil
+		},
+	}
+}
+
+// instructions tells how to prove ownership of host for the tunnel opened with key
+func (v *domainVerifier) instructions(host, key, name, domain string) string {
+	token := v.token(host, key)
+	return fmt.Sprintf("verify %s for tunnel %s with either\n"+
+		"  a TXT record   %s%s  \"%s%s\"\n"+
+		"  or the token   %s  served at http://%s%s\n"+
+		"then point it here with a CNAME record to %s.%s\n",
+		host, name, verificationRecord, host, verificationPrefix, token,
+		token, host, verificationPath, name, domain)
+}
+
+// isCustomDomain reports whether host can be claimed as a custom domain, a name with more than
+// one label that isn't under the domains, nor an address or a name of the local network
+func (r *hostRouter) isCustomDomain(host string) bool {
+	if host != normalizeHost(host) || !strings.Contains(host, ".") || strings.ContainsAny(host, "/@ []") {
+		return false
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return false
+	}
+	for _, local := range []string{".localhost", ".local", ".internal", ".home.arpa"} {
+		if strings.HasSuffix(host, local) {
+			return false
+		}
+	}
+	return !slices.Contains(r.domains, host) && r.match(host) == ""
+}
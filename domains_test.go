@@ -0,0 +1,214 @@
+package echogy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHostRouterRoute(t *testing.T) {
+	customDomains.Store("api.customer.com", "myapp")
+	defer customDomains.Delete("api.customer.com")
+
+	tests := []struct {
+		depth      int
+		host       string
+		wantId     string
+		wantDomain string
+		wantOk     bool
+	}{
+		{host: "api.customer.com", wantId: "myapp", wantOk: true},
+		{host: "API.Customer.com:443", wantId: "myapp", wantOk: true},
+		{host: "demo.webs.sh", wantId: "demo", wantDomain: "webs.sh", wantOk: true},
+		{host: "Demo.WEBS.sh:80", wantId: "demo", wantDomain: "webs.sh", wantOk: true},
+		{host: "demo.webs.sh.", wantId: "demo", wantDomain: "webs.sh", wantOk: true},
+		{host: "demo.staging.webs.sh", wantId: "demo", wantDomain: "staging.webs.sh", wantOk: true},
+		{host: "demo.echogy.dev", wantId: "demo", wantDomain: "echogy.dev", wantOk: true},
+		{host: "a.b.webs.sh"},
+		{host: "a.b.webs.sh", depth: 2, wantId: "b", wantDomain: "webs.sh", wantOk: true},
+		{host: "a.b.c.webs.sh", depth: 2},
+		{host: ".webs.sh", depth: 2},
+		{host: "a..webs.sh", depth: 2},
+		{host: "abc123.evil.com"},
+		{host: "www.customer.com"},
+		{host: "demo.evilwebs.sh"},
+		{host: "webs.sh"},
+		{host: "localhost"},
+	}
+	for _, tt := range tests {
+		router := newHostRouter(tt.depth, "webs.sh", "staging.webs.sh", "echogy.dev")
+		id, domain, ok := router.route(tt.host)
+		if id != tt.wantId || domain != tt.wantDomain || ok != tt.wantOk {
+			t.Errorf("route(%q) at depth %d = %q, %q, %v, want %q, %q, %v", tt.host, tt.depth,
+				id, domain, ok, tt.wantId, tt.wantDomain, tt.wantOk)
+		}
+	}
+}
+
+func TestLandingDomains(t *testing.T) {
+	domains := []Domain{
+		{Name: "webs.sh"},
+		{Name: "echogy.dev"},
+		{Name: "staging.webs.sh", SSHUserSuffix: "+staging"},
+		{Name: "qa.webs.sh", SSHAddr: ":2223"},
+	}
+	tests := map[string][]string{
+		"alice":         {"webs.sh", "echogy.dev"},
+		"alice+staging": {"staging.webs.sh"},
+		"":              {"webs.sh", "echogy.dev"},
+	}
+	for user, want := range tests {
+		if got := landingDomains(domains, user); !slices.Equal(got, want) {
+			t.Errorf("landingDomains(%q) = %v, want %v", user, got, want)
+		}
+	}
+
+	fwd := &forwarder{accessId: "landing", domains: []string{"staging.webs.sh"}}
+	sessionHub.Store(fwd.accessId, fwd)
+	defer sessionHub.Delete(fwd.accessId)
+	for domain, want := range map[string]bool{"staging.webs.sh": true, "webs.sh": false, "": true} {
+		if _, found := lookupTunnel(fwd.accessId, domain); found != want {
+			t.Errorf("lookupTunnel on %q found = %v, want %v", domain, found, want)
+		}
+	}
+}
+
+func TestInUse(t *testing.T) {
+	holder := &forwarder{domains: []string{"staging.webs.sh"}}
+	if got := inUse("app", holder, []string{"staging.webs.sh"}); got != "tunnel name app is already in use" {
+		t.Errorf("inUse() on the same domain = %q", got)
+	}
+	want := "tunnel name app is already in use on staging.webs.sh, tunnel names are shared by every domain of the server"
+	if got := inUse("app", holder, []string{"webs.sh", "echogy.dev"}); got != want {
+		t.Errorf("inUse() on another domain = %q", got)
+	}
+}
+
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	if records, ok := f[name]; ok {
+		return records, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func TestDomainVerifier(t *testing.T) {
+	resolver := fakeResolver{}
+	v := newDomainVerifier(resolver, []byte("server key"))
+	token := v.token("txt.customer.com", "team-secret")
+	if token != newDomainVerifier(nil, []byte("server key")).token("txt.customer.com", "team-secret") {
+		t.Fatal("the token changes across verifiers with the same key")
+	}
+	resolver["_echogy.txt.customer.com"] = []string{"v=spf1 -all", "echogy-verification=" + token}
+
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == verificationPath && r.Host == "www.customer.com" {
+			io.WriteString(w, v.token("www.customer.com", "team-secret")+"\n")
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer site.Close()
+	v.client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return net.Dial(network, site.Listener.Addr().String())
+		},
+	}
+
+	tests := []struct {
+		host, key string
+		wantErr   bool
+	}{
+		{host: "txt.customer.com", key: "team-secret"},
+		{host: "txt.customer.com", key: "guessed-it", wantErr: true},
+		{host: "www.customer.com", key: "team-secret"},
+		{host: "www.customer.com", key: "guessed-it", wantErr: true},
+		{host: "api.customer.com", key: "team-secret", wantErr: true},
+	}
+	for _, tt := range tests {
+		err := v.verify(context.Background(), tt.host, tt.key)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("verify(%s, %s) = %v, wantErr %v", tt.host, tt.key, err, tt.wantErr)
+		}
+	}
+
+	// once verified, the domain may point here and the HTTP check can't pass again
+	site.Close()
+	if err := v.verify(context.Background(), "www.customer.com", "team-secret"); err != nil {
+		t.Errorf("verify after the site moved = %v", err)
+	}
+	// until the owner leaves, or the check expires
+	v.forget("www.customer.com", "team-secret")
+	if err := v.verify(context.Background(), "www.customer.com", "team-secret"); err == nil {
+		t.Error("verify passed after the owner left")
+	}
+	v.verified.Store(v.token("www.customer.com", "team-secret"), time.Now().Add(-time.Second))
+	if err := v.verify(context.Background(), "www.customer.com", "team-secret"); err == nil {
+		t.Error("verify passed with an expired check")
+	}
+}
+
+func TestIsCustomDomain(t *testing.T) {
+	tests := map[string]bool{
+		"api.customer.com": true,
+		"demo.webs.sh":     false,
+		"webs.sh":          false,
+		"localhost":        false,
+		"API.customer.com": false,
+		"a.b:80":           false,
+		"demo.echogy.dev":  false,
+		"10.0.0.1":         false,
+		"169.254.169.254":  false,
+		"::1":              false,
+		"[::1]":            false,
+		"intranet":         false,
+		"app.localhost":    false,
+		"printer.local":    false,
+	}
+	router := newHostRouter(1, "webs.sh", "echogy.dev")
+	for host, want := range tests {
+		if got := router.isCustomDomain(host); got != want {
+			t.Errorf("isCustomDomain(%q) = %v, want %v", host, got, want)
+		}
+	}
+}
+
+func TestPublicDialer(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.215.14":   true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"::1":             false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+		"0.0.0.0":         false,
+	}
+	for addr, want := range tests {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if conn, err := publicDialer().DialContext(context.Background(), "tcp", ln.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("dialed a loopback address")
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"github.com/gliderlabs/ssh"
 	"github.com/youkale/echogy/logger"
+	"github.com/youkale/echogy/pkg/errorpage"
 	gossh "golang.org/x/crypto/ssh"
+	"slices"
 	"sync"
+	"time"
 )
 
 var sessionHub sync.Map
@@ -63,8 +66,24 @@ func requestHandler(bindPort uint32) func(ctx ssh.Context, _ *ssh.Server, req *g
 				})
 				return false, []byte{}
 			}
-			id := ctx.Value(sshAccessIdKey).(string)
-			sessionHub.Delete(id)
+			if id, ok := ctx.Value(sshAccessIdKey).(string); ok {
+				if value, found := sessionHub.Load(id); found {
+					fwd := value.(*forwarder)
+					if nil != fwd.pool {
+						for _, member := range fwd.pool.snapshot() {
+							if member.sess.Context() == ctx {
+								fwd.pool.leave(member)
+							}
+						}
+					} else if fwd.sess.Context() == ctx {
+						sessionHub.Delete(id)
+					}
+					if fwd.path != "" {
+						releasePathPrefix(fwd.name, fwd.path)
+					}
+					releaseSplits()
+				}
+			}
 			return true, nil
 		default:
 			return false, nil
@@ -72,7 +91,7 @@ func requestHandler(bindPort uint32) func(ctx ssh.Context, _ *ssh.Server, req *g
 	}
 }
 
-func newSshServer(sshAddr string, facadeDomain string, sshKey []byte, bindPort uint32) *ssh.Server {
+func newSshServer(sshAddr string, sshKey []byte, bindPort uint32, handler ssh.Handler) *ssh.Server {
 	key, _ := gossh.ParseRawPrivateKey(sshKey)
 	signer, _ := gossh.NewSignerFromKey(key)
 
@@ -86,7 +105,7 @@ func newSshServer(sshAddr string, facadeDomain string, sshKey []byte, bindPort u
 		PtyCallback: func(ctx ssh.Context, pty ssh.Pty) bool {
 			return true
 		},
-		Handler: sessionHandler(facadeDomain),
+		Handler: handler,
 		ReversePortForwardingCallback: func(ctx ssh.Context, bindHost string, bindPort uint32) bool {
 			return true
 		},
@@ -97,9 +116,109 @@ func newSshServer(sshAddr string, facadeDomain string, sshKey []byte, bindPort u
 	}
 }
 
-func sessionHandler(domain string) func(session ssh.Session) {
+// sessionHandler serves the tunnels of an ssh listener, domains picks where a tunnel lands
+func sessionHandler(domains func(session ssh.Session) []string, router *hostRouter, verifier *domainVerifier) func(session ssh.Session) {
 	return func(session ssh.Session) {
-		id, err := withAddrGenerateAccessId(session.RemoteAddr())
+		if args := session.Command(); len(args) > 0 {
+			switch args[0] {
+			case "split":
+				session.Exit(splitCommand(session, args[1:]))
+				return
+			case "har":
+				session.Exit(exportCommand(session, args[1:]))
+				return
+			}
+		}
+
+		landing := domains(session)
+		if len(landing) == 0 {
+			fmt.Fprintf(session, "no domain for user %s\n", session.User())
+			session.Exit(1)
+			return
+		}
+
+		fwdReq, _ := session.Context().Value(sshRequestForward).(*remoteForwardRequest)
+		name := ""
+		if nil != fwdReq {
+			name = requestedAccessId(fwdReq.BindAddr)
+		}
+
+		// the options of the session come after the configured ones of the name and override them
+		opts, err := parseTunnelOptions(append(slices.Clone(configuredOptions[name]), session.Command()...))
+		if nil != err {
+			fmt.Fprintf(session, "%v\n", err)
+			session.Exit(1)
+			return
+		}
+
+		if opts.pool != "" && name == "" {
+			fmt.Fprintf(session, "option pool needs a tunnel name, e.g. ssh -R myapp:80:localhost:3000\n")
+			session.Exit(1)
+			return
+		}
+		if opts.path != "" && name == "" {
+			fmt.Fprintf(session, "option path needs a tunnel name, e.g. ssh -R myapp:80:localhost:3000\n")
+			session.Exit(1)
+			return
+		}
+
+		if opts.domain != "" {
+			if name == "" {
+				fmt.Fprintf(session, "option domain needs a tunnel name, e.g. ssh -R myapp:80:localhost:3000\n")
+				session.Exit(1)
+				return
+			}
+			if !router.isCustomDomain(opts.domain) {
+				fmt.Fprintf(session, "option domain: %q is not a custom domain\n", opts.domain)
+				session.Exit(1)
+				return
+			}
+			if err := verifier.verify(session.Context(), opts.domain, opts.key); err != nil {
+				logger.Warn("custom domain not verified", map[string]interface{}{
+					"module":   "serve",
+					"accessId": name,
+					"domain":   opts.domain,
+					"error":    err.Error(),
+				})
+				fmt.Fprintf(session, "%v\n%s", err, verifier.instructions(opts.domain, opts.key, name, landing[0]))
+				session.Exit(1)
+				return
+			}
+		}
+
+		var id string
+		if name != "" {
+			id = name
+			if value, split := splits.Load(name); split {
+				if value.(*trafficSplit).live() {
+					fmt.Fprintf(session, "tunnel name %s is split over other tunnels\n", name)
+					session.Exit(1)
+					return
+				}
+				if value.(*trafficSplit).key != "" {
+					// none of its targets is left, the name is free again
+					splits.CompareAndDelete(name, value)
+				}
+			}
+			if value, found := sessionHub.Load(id + opts.path); found && !joinable(value.(*forwarder), opts) {
+				logger.Warn("tunnel name in use", map[string]interface{}{
+					"module":     "serve",
+					"accessId":   id + opts.path,
+					"remoteAddr": session.RemoteAddr().String(),
+				})
+				fmt.Fprintf(session, "%s\n", inUse(id+opts.path, value.(*forwarder), landing))
+				session.Exit(1)
+				return
+			}
+			if !mayShareName(id, opts.key, nil) {
+				fmt.Fprintf(session, "tunnel name %s is served by other tunnels, share it with their key=<secret>\n", id)
+				session.Exit(1)
+				return
+			}
+			goto established
+		}
+
+		id, err = withAddrGenerateAccessId(session.RemoteAddr())
 	regenerating:
 		if nil != err {
 			logger.Error("generating accessId", err, map[string]interface{}{
@@ -114,7 +233,8 @@ func sessionHandler(domain string) func(session ssh.Session) {
 			goto regenerating
 		}
 
-		channel, err := newForwarder(id, domain, session)
+	established:
+		channel, err := newForwarder(id, landing, session, opts)
 
 		if nil != err {
 			logger.Error("create forward", err, map[string]interface{}{
@@ -123,14 +243,49 @@ func sessionHandler(domain string) func(session ssh.Session) {
 			})
 			return
 		}
-		session.Context().SetValue(sshAccessIdKey, id)
-		sessionHub.Store(id, channel)
+		// claiming closes the window between the lookup above and a concurrent session claiming the same name
+		if !claimTunnel(channel.accessId, channel) {
+			message := fmt.Sprintf("tunnel name %s is already in use", channel.accessId)
+			if value, ok := sessionHub.Load(channel.accessId); ok {
+				message = inUse(channel.accessId, value.(*forwarder), landing)
+			}
+			fmt.Fprintf(session, "%s\n", message)
+			session.Exit(1)
+			return
+		}
+		if channel.path != "" {
+			addPathPrefix(id, channel.path)
+			defer releasePathPrefix(id, channel.path)
+		}
+		// a tunnel sharing the name may have been claimed since the check above
+		if name != "" && !mayShareName(id, opts.key, channel) {
+			releaseTunnel(channel.accessId, channel)
+			fmt.Fprintf(session, "tunnel name %s is served by other tunnels, share it with their key=<secret>\n", id)
+			session.Exit(1)
+			return
+		}
+		if opts.domain != "" {
+			if owner, loaded := customDomains.LoadOrStore(opts.domain, id); loaded && owner != id {
+				releaseTunnel(channel.accessId, channel)
+				fmt.Fprintf(session, "domain %s is already served by another tunnel\n", opts.domain)
+				session.Exit(1)
+				return
+			}
+			defer func() {
+				// pooled sessions keep serving the domain until the last one leaves
+				if _, served := sessionHub.Load(channel.accessId); !served && customDomains.CompareAndDelete(opts.domain, id) {
+					verifier.forget(opts.domain, opts.key)
+				}
+			}()
+		}
+		session.Context().SetValue(sshAccessIdKey, channel.accessId)
 		logger.Debug("establishing ssh session", map[string]interface{}{
 			"module":   "session",
 			"accessId": id,
 		})
 		channel.serve() // blocked with loop
-		sessionHub.Delete(id)
+		releaseTunnel(channel.accessId, channel)
+		releaseSplits()
 		logger.Debug("clean ssh session", map[string]interface{}{
 			"module":   "session",
 			"accessId": id,
@@ -139,21 +294,119 @@ func sessionHandler(domain string) func(session ssh.Session) {
 	}
 }
 
-func Serve(_ctx context.Context, sshAddr, facadeAddr, facadeDomain string, sshKey []byte) {
+// Options holds everything Serve needs to run an echogy instance
+type Options struct {
+	SSHAddr   string
+	HttpAddr  string
+	HttpsAddr string // facade over TLS, with HTTP/2, disabled when empty
+	TLSCert   string // certificate and key files for HttpsAddr, for any domain without its own
+	TLSKey    string
+	AdminAddr string   // admin api listen address, disabled when empty
+	Resolver  Resolver // looks up custom domain TXT records, the system resolver when nil
+	Domain    string
+	// ErrorPages is a directory of templates for the error pages, like 404.html or error.json
+	ErrorPages string
+	// ChannelOpenTimeout bounds how long the client may take to accept a connection
+	// before the facade answers 504, 10s when zero
+	ChannelOpenTimeout time.Duration
+	// ReadHeaderTimeout bounds how long a facade client may take to send the request line
+	// and headers, answered with 408, 10s when zero
+	ReadHeaderTimeout time.Duration
+	MaxHeaderBytes    int // request line and headers, larger ones are answered with 431, 1MB when zero
+	MaxRequestLine    int // longer request lines are answered with 414, 8KB when zero
+	// IdleTimeout closes facade connections carrying nothing either way, 2m when zero
+	IdleTimeout time.Duration
+	Domains     []Domain // more apex domains tunnels are reachable under, besides Domain
+	// SubdomainDepth is how many labels a host may have under a domain, the tunnel
+	// name is the one next to the domain, 1 when zero
+	SubdomainDepth int
+	// TunnelOptions are options applied to the tunnels of a name before those of their
+	// session, e.g. {"app": ["host=localhost:3000"]}
+	TunnelOptions map[string][]string
+	PrivateKey    []byte
+	Version       string // reported by the admin status endpoint
+}
+
+// sshListener is an ssh server and the domains its tunnels land on
+type sshListener struct {
+	addr    string
+	domains func(session ssh.Session) []string
+}
+
+func Serve(_ctx context.Context, opts *Options) {
 
 	wg := sync.WaitGroup{}
 
-	_, sshPort, err := parseHostAddr(sshAddr)
-	if err != nil {
-		logger.Fatal("parse net.Addr failed", err, map[string]interface{}{
-			"module": "serve",
-		})
-		return
+	sshAddr, facadeAddr, facadeDomain := opts.SSHAddr, opts.HttpAddr, opts.Domain
+
+	domains := append([]Domain{{Name: facadeDomain}}, opts.Domains...)
+	names := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		names = append(names, domain.Name)
+	}
+	listeners := []sshListener{{addr: sshAddr, domains: func(session ssh.Session) []string {
+		return landingDomains(domains, session.User())
+	}}}
+	for _, domain := range opts.Domains {
+		if domain.SSHAddr != "" {
+			only := []string{domain.Name}
+			listeners = append(listeners, sshListener{addr: domain.SSHAddr, domains: func(ssh.Session) []string {
+				return only
+			}})
+		}
+	}
+
+	configuredOptions = opts.TunnelOptions
+	if opts.ChannelOpenTimeout > 0 {
+		channelOpenTimeout = opts.ChannelOpenTimeout
+	}
+	configureLimits(facadeLimits{
+		readHeaderTimeout: opts.ReadHeaderTimeout,
+		maxHeaderBytes:    opts.MaxHeaderBytes,
+		maxRequestLine:    opts.MaxRequestLine,
+		idleTimeout:       opts.IdleTimeout,
+	})
+	if opts.ErrorPages != "" {
+		pages, err := errorpage.Load(opts.ErrorPages)
+		if err != nil {
+			logger.Fatal("load error pages", err, map[string]interface{}{
+				"module": "serve",
+				"dir":    opts.ErrorPages,
+			})
+			return
+		}
+		errorPages = pages
 	}
 
 	ctx, cancelFunc := context.WithCancel(_ctx)
 
-	server := newSshServer(sshAddr, facadeDomain, sshKey, sshPort)
+	verifier := newDomainVerifier(opts.Resolver, opts.PrivateKey)
+	router := newHostRouter(opts.SubdomainDepth, names...)
+	var servers []*ssh.Server
+	for _, listener := range listeners {
+		_, sshPort, err := parseHostAddr(listener.addr)
+		if err != nil {
+			logger.Fatal("parse net.Addr failed", err, map[string]interface{}{
+				"module":  "serve",
+				"address": listener.addr,
+			})
+			return
+		}
+		servers = append(servers, newSshServer(listener.addr, opts.PrivateKey, sshPort,
+			sessionHandler(listener.domains, router, verifier)))
+	}
+
+	if opts.AdminAddr != "" {
+		go adminServe(ctx, opts.AdminAddr, opts.Version)
+	}
+
+	forward := func(facadeId, domain string, req *hijackConn) bool {
+		facadeId = splitRoute(facadeId, req.request)
+		if channel, found := lookupTunnel(facadeId, domain); found {
+			return channel.forward(req)
+		}
+		return false
+	}
 
 	wg.Add(1)
 	go func() {
@@ -162,35 +415,49 @@ func Serve(_ctx context.Context, sshAddr, facadeAddr, facadeDomain string, sshKe
 			"module":  "serve",
 			"address": facadeAddr,
 		})
-		facadeServe(ctx, facadeAddr, func(facadeId string, req *hijackConn) bool {
-			if value, found := sessionHub.Load(facadeId); found {
-				channel := value.(*forwarder)
-				channel.forward(req)
-				return true
-			}
-			return false
-		})
+		facadeServe(ctx, facadeAddr, router, forward)
 	}()
 
 	wg.Wait()
 
-	wg.Add(1)
-	go func() {
-		wg.Done()
-		logger.Warn("started ssh server", map[string]interface{}{
-			"module":  "serve",
-			"address": sshAddr,
-		})
-		err := server.ListenAndServe()
-		logger.Fatal("ssh server", err, map[string]interface{}{
+	if opts.HttpsAddr != "" {
+		config, err := tlsConfig(opts.TLSCert, opts.TLSKey, router, opts.Domains)
+		if err != nil {
+			logger.Fatal("load tls certificate", err, map[string]interface{}{
+				"module": "serve",
+			})
+			return
+		}
+		logger.Warn("started tls facade server", map[string]interface{}{
 			"module":  "serve",
-			"address": sshAddr,
+			"address": opts.HttpsAddr,
 		})
-	}()
+		go facadeServeTLS(ctx, opts.HttpsAddr, config, router, forward)
+	}
+
+	for _, server := range servers {
+		wg.Add(1)
+		go func() {
+			wg.Done()
+			logger.Warn("started ssh server", map[string]interface{}{
+				"module":  "serve",
+				"address": server.Addr,
+			})
+			err := server.ListenAndServe()
+			if err != ssh.ErrServerClosed {
+				logger.Fatal("ssh server", err, map[string]interface{}{
+					"module":  "serve",
+					"address": server.Addr,
+				})
+			}
+		}()
+	}
 	wg.Wait()
 
 	<-_ctx.Done()
-	server.Shutdown(ctx)
+	for _, server := range servers {
+		server.Shutdown(ctx)
+	}
 	logger.Warn("echogy shutdown", map[string]interface{}{})
 	cancelFunc()
 }
@@ -0,0 +1,81 @@
+package echogy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/karlseguin/ccache/v3"
+	"github.com/youkale/echogy/logger"
+)
+
+const (
+	exportTTL      = 10 * time.Minute
+	exportTokenLen = 32
+)
+
+// harExport is a HAR document waiting to be downloaded, once, with the har command of the ssh
+// server. It holds the captured traffic, cookies and credentials included, so it isn't served
+// on the public host of the tunnel.
+type harExport struct {
+	accessId  string
+	data      []byte
+	createdAt time.Time
+}
+
+var exports = ccache.New(ccache.Configure[*harExport]().MaxSize(1024))
+
+// publishExport keeps a HAR document for a while and returns the command downloading it,
+// sshCommand reaches the ssh server, like "ssh -p 2222 webs.sh"
+func publishExport(accessId, sshCommand string, data []byte) (string, error) {
+	token, err := generateRandomString(exportTokenLen, AlphaNum)
+	if err != nil {
+		return "", err
+	}
+	export := &harExport{accessId: accessId, data: data, createdAt: time.Now()}
+	exports.Set(token, export, exportTTL)
+	return fmt.Sprintf("%s har %s > %s (once, within %s)", sshCommand, token, export.filename(), exportTTL), nil
+}
+
+// takeExport returns the export of a token and forgets it, nil when there is none
+func takeExport(token string) *harExport {
+	item := exports.Get(token)
+	if item == nil || item.Expired() || !exports.Delete(token) {
+		return nil
+	}
+	return item.Value()
+}
+
+func (export *harExport) filename() string {
+	return fmt.Sprintf("%s-%s.har", export.accessId, export.createdAt.Format("20060102-150405"))
+}
+
+// exportCommand runs `ssh webs.sh har <token>`, it writes the HAR document of the token
+func exportCommand(w io.Writer, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(w, "usage: har <token>, the token is shown by the dashboard on export\n")
+		return 1
+	}
+	export := takeExport(args[0])
+	if nil == export {
+		fmt.Fprintf(w, "no export %s, exports are downloaded once within %s\n", args[0], exportTTL)
+		return 1
+	}
+	w.Write(export.data)
+	logger.Info("served HAR export", map[string]interface{}{
+		"module":   "export",
+		"accessId": export.accessId,
+		"size":     len(export.data),
+	})
+	return 0
+}
+
+// sshCommand is the command reaching the ssh server the tunnel was opened on
+func (fwd *forwarder) sshCommand() string {
+	host := fwd.domains[0]
+	if _, port, err := net.SplitHostPort(fwd.sess.LocalAddr().String()); err == nil && port != "22" {
+		return fmt.Sprintf("ssh -p %s %s", port, host)
+	}
+	return "ssh " + host
+}
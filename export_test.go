@@ -0,0 +1,31 @@
+package echogy
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestExportCommand(t *testing.T) {
+	download, err := publishExport("app", "ssh -p 2222 webs.sh", []byte(`{"log":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(download)
+	if len(fields) < 6 || fields[4] != "har" {
+		t.Fatalf("download = %q", download)
+	}
+	token := fields[5]
+
+	out := &strings.Builder{}
+	if code := exportCommand(out, []string{token}); code != 0 || out.String() != `{"log":{}}` {
+		t.Errorf("har %s = %d, %q", token, code, out)
+	}
+	// an export is downloaded once
+	if code := exportCommand(io.Discard, []string{token}); code == 0 {
+		t.Error("export downloaded twice")
+	}
+	if code := exportCommand(io.Discard, []string{"guessed"}); code == 0 {
+		t.Error("export of an unknown token")
+	}
+}
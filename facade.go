@@ -1,69 +1,100 @@
 package echogy
 
 import (
-	"bufio"
 	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/youkale/echogy/logger"
+	"github.com/youkale/echogy/pkg/errorpage"
+	"golang.org/x/net/http2"
 	"net"
 	"net/http"
-	"strings"
+	"time"
 )
 
-const (
-	NotFound = `HTTP/1.0 404 Not Found
-Server: webs.sh
-Content-Length: %d
+// tlsHandshakeTimeout bounds the TLS handshake of a facade connection
+const tlsHandshakeTimeout = 10 * time.Second
 
-Tunnel %s not found
-`
+// errorPages renders the error responses of the facade, Serve swaps in the operator templates
+var errorPages = errorpage.Default()
 
-	BadRequest = `HTTP/1.0 400 Bad Request
-Server: webs.sh
-Content-Length: 12
-
-Bad Request
-`
-)
-
-func badRequest(conn net.Conn) {
-	conn.Write([]byte(BadRequest))
+// errorPage answers a facade connection with an error page and closes it, req is nil when
+// the request couldn't be read. It returns the request ID shown on the page.
+func errorPage(conn net.Conn, req *http.Request, status int, message string) string {
+	page := errorpage.NewPage(status, message)
+	errorPages.Response(req, page).Write(conn)
 	conn.Close()
+	return page.RequestID
 }
 
-func notFound(id string, conn net.Conn) {
-	conn.Write([]byte(fmt.Sprintf(NotFound, len(id)+18, id)))
-	conn.Close()
+// writeErrorPage answers a proxied request with an error page
+func writeErrorPage(w http.ResponseWriter, r *http.Request, status int, message string) string {
+	page := errorpage.NewPage(status, message)
+	errorPages.Write(w, r, page)
+	return page.RequestID
 }
 
-func handleConnection(c net.Conn, forward func(facadeId string, request *hijackConn) bool) {
+// isH2Preface reports whether a request is the connection preface of HTTP/2 with prior knowledge,
+// which reads as a request line "PRI * HTTP/2.0"
+func isH2Preface(req *http.Request) bool {
+	return req.Method == "PRI" && req.RequestURI == "*" && req.ProtoMajor == 2
+}
+
+func handleConnection(c net.Conn, router *hostRouter, forward func(facadeId, domain string, request *hijackConn) bool) {
 	reader := newBufferedReader(c)
-	req, err := http.ReadRequest(bufio.NewReader(reader))
+	req, err := readRequestHead(c, reader)
 	if err != nil {
+		r := err.(*rejection)
 		logger.Warn("bad request", map[string]interface{}{
-			"module": "facade",
+			"module":     "facade",
+			"status":     r.status,
+			"remoteAddr": c.RemoteAddr().String(),
+			"error":      r.Error(),
+			"requestId":  r.requestId,
 		})
-		badRequest(c)
 		return
 	}
 
-	domainSep := strings.Split(req.Host, ".")
-	if len(domainSep) <= 1 {
+	if isH2Preface(req) {
+		// h2c, the preface is read again by the HTTP/2 server
+		serveH2(reader.toBufferedConn(c), router)
+		return
+	}
+
+	if req.Host == "" {
+		requestId := errorPage(c, req, http.StatusBadRequest, "The request has no Host header.")
 		logger.Warn("bad request", map[string]interface{}{
-			"module": "facade",
-			"method": req.Method,
-			"url":    req.URL.String(),
-			"host":   req.Host,
+			"module":    "facade",
+			"method":    req.Method,
+			"url":       req.URL.String(),
+			"requestId": requestId,
+		})
+		return
+	}
+	id, domain, ok := router.route(req.Host)
+	if !ok {
+		requestId := errorPage(c, req, http.StatusMisdirectedRequest, "")
+		logger.Warn("misdirected request", map[string]interface{}{
+			"module":    "facade",
+			"method":    req.Method,
+			"host":      req.Host,
+			"requestId": requestId,
 		})
-		badRequest(c)
 		return
 	}
-	id := domainSep[0]
 
+	if routedPerRequest(id) {
+		// each request on the connection is routed on its own, the buffered one included
+		serveHTTP(reader.toBufferedConn(c), router)
+		return
+	}
+
+	// the buffered request is replayed through the hijacked conn, which records it on the first read
 	conn := newHijackConn(reader.toBufferedConn(c))
-	conn.AddRequest(req)
+	req.RemoteAddr = c.RemoteAddr().String()
+	conn.request = req
 
-	canForward := forward(id, conn)
+	canForward := forward(id, domain, conn)
 
 	if canForward {
 		logger.Debug("found forward", map[string]interface{}{
@@ -73,17 +104,48 @@ func handleConnection(c net.Conn, forward func(facadeId string, request *hijackC
 			"path":     req.URL.Path,
 		})
 	} else {
-		notFound(id, c)
+		requestId := errorPage(c, req, http.StatusNotFound, fmt.Sprintf("Tunnel %s not found.", id))
 		logger.Warn("not found forward", map[string]interface{}{
-			"module":   "facade",
-			"method":   req.Method,
-			"accessId": id,
-			"url":      req.URL.String(),
+			"module":    "facade",
+			"method":    req.Method,
+			"accessId":  id,
+			"url":       req.URL.String(),
+			"requestId": requestId,
 		})
 	}
 }
 
-func facadeServe(ctx context.Context, addr string, forward func(facadeId string, request *hijackConn) bool) {
+// routedPerRequest reports whether the connections to id can't be piped to a single tunnel
+// untouched, because tunnels share the host by path or a tunnel they may reach rewrites requests
+func routedPerRequest(id string) bool {
+	names := []string{id}
+	if value, found := splits.Load(id); found {
+		for _, target := range value.(*trafficSplit).Targets {
+			names = append(names, target.Tunnel)
+		}
+	}
+	for _, name := range names {
+		if hasPathRoutes(name) {
+			return true
+		}
+		value, found := sessionHub.Load(name)
+		if !found {
+			continue
+		}
+		members := []*forwarder{value.(*forwarder)}
+		if pool := members[0].pool; nil != pool {
+			members = pool.snapshot()
+		}
+		for _, member := range members {
+			if member.opts.perRequest() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func facadeServe(ctx context.Context, addr string, router *hostRouter, forward func(facadeId, domain string, request *hijackConn) bool) {
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		logger.Fatal("start Listen", err, map[string]interface{}{
@@ -111,8 +173,61 @@ func facadeServe(ctx context.Context, addr string, forward func(facadeId string,
 				})
 				c.Close()
 			} else {
-				go handleConnection(c, forward)
+				go handleConnection(c, router, forward)
 			}
 		}
 	}
 }
+
+// facadeServeTLS serves the facade over TLS, clients negotiating h2 with ALPN get HTTP/2,
+// everyone else takes the same path as plain HTTP/1.x connections
+func facadeServeTLS(ctx context.Context, addr string, config *tls.Config, router *hostRouter, forward func(facadeId, domain string, request *hijackConn) bool) {
+	config = config.Clone()
+	config.NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		logger.Fatal("start Listen", err, map[string]interface{}{
+			"module":  "facade",
+			"address": addr,
+		})
+		return
+	}
+	context.AfterFunc(ctx, func() {
+		ln.Close()
+	})
+
+	for {
+		c, err := ln.Accept()
+		if nil != err {
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Error("start Accept", err, map[string]interface{}{
+				"module":  "facade",
+				"address": addr,
+			})
+			continue
+		}
+		go handleTLSConnection(tls.Server(c, config), router, forward)
+	}
+}
+
+func handleTLSConnection(c *tls.Conn, router *hostRouter, forward func(facadeId, domain string, request *hijackConn) bool) {
+	hsCtx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+	if err := c.HandshakeContext(hsCtx); err != nil {
+		logger.Debug("tls handshake", map[string]interface{}{
+			"module":     "facade",
+			"remoteAddr": c.RemoteAddr().String(),
+			"error":      err.Error(),
+		})
+		c.Close()
+		return
+	}
+	if c.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+		serveH2(c, router)
+		return
+	}
+	handleConnection(c, router, forward)
+}
@@ -1,16 +1,23 @@
 package echogy
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gliderlabs/ssh"
 	"github.com/youkale/echogy/logger"
+	"github.com/youkale/echogy/pkg/capture"
+	"github.com/youkale/echogy/pkg/errorpage"
 	"github.com/youkale/echogy/tui"
 	gossh "golang.org/x/crypto/ssh"
 	"io"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -18,31 +25,78 @@ type forwarder struct {
 	context    context.Context
 	cancelFunc context.CancelFunc
 	sess       ssh.Session
-	accessId   string
+	accessId   string // key in sessionHub, the name followed by the path prefix
+	name       string // tunnel name, the label of its hosts
+	path       string // path prefix the tunnel serves, empty for every path
 	pty        *tui.Tui
 	reqChan    chan net.Conn
 	bindAddr   string
 	bindPort   uint32
+	url        string   // where the tunnel is reachable, the first of urls
+	urls       []string // every host the tunnel is reachable at
+	domains    []string // apex domains the tunnel lands on
+	opts       *tunnelOptions
+	fwdReq     *remoteForwardRequest
+	svrConn    *gossh.ServerConn
+	createdAt  time.Time
+	connCount  atomic.Int64
+	bytesIn    atomic.Int64 // read from facade clients
+	bytesOut   atomic.Int64 // read from the tunnel, for facade clients
+	active     atomic.Int64 // connections being served
+
+	pool      *tunnelPool  // nil unless opened with the pool option
+	poolBase  int64        // connections given to the pool before this member joined
+	poolConns atomic.Int64 // connections given to this member by the pool
+	droppedAt atomic.Int64 // unix nanoseconds the member went out of rotation, 0 when in
+
+	transportOnce sync.Once
+	transport     http.RoundTripper // proxies requests that don't take the raw path, see roundTripper
 }
 
+// totals across every tunnel, including the closed ones
+var (
+	totalBytesIn  atomic.Int64
+	totalBytesOut atomic.Int64
+)
+
 type facadeRequest struct {
 	net.Conn
 	request *http.Request
 }
 
-func newForwarder(accessId, domain string, session ssh.Session) (*forwarder, error) {
-	pty, err := tui.NewPty(session, fmt.Sprintf("%s.%s", accessId, domain))
-	if err != nil {
-		return nil, err
+func newForwarder(accessId string, domains []string, session ssh.Session, opts *tunnelOptions) (*forwarder, error) {
+	var urls []string
+	if opts.domain != "" {
+		urls = append(urls, opts.domain+opts.path)
+	}
+	for _, domain := range domains {
+		urls = append(urls, fmt.Sprintf("%s.%s%s", accessId, domain, opts.path))
+	}
+	url := urls[0]
+	var pty *tui.Tui
+	// sessions without a pty, like `ssh -T` or the echogy client, run headless
+	if _, _, hasPty := session.Pty(); hasPty {
+		var err error
+		pty, err = tui.NewPty(session, url, urls[1:]...)
+		if err != nil {
+			return nil, err
+		}
 	}
 	ctx, cancelFunc := context.WithCancel(session.Context())
 	return &forwarder{
 		context:    ctx,
 		cancelFunc: cancelFunc,
-		accessId:   accessId,
+		accessId:   accessId + opts.path,
+		name:       accessId,
+		path:       opts.path,
 		pty:        pty,
 		sess:       session,
 		reqChan:    make(chan net.Conn, 4),
+		url:        url,
+		urls:       urls,
+		domains:    domains,
+		opts:       opts,
+		createdAt:  time.Now(),
 	}, nil
 }
 
@@ -53,9 +107,28 @@ type remoteForwardChannelData struct {
 	OriginPort uint32
 }
 
-func (fwd *forwarder) forward(hijackConn *hijackConn) {
-	hijackConn.SetDispatch(fwd.pty.Notify)
-	fwd.reqChan <- hijackConn
+// forward hands a connection to the tunnel, it reports false when the tunnel ended first
+func (fwd *forwarder) forward(hijackConn *hijackConn) bool {
+	if nil != fwd.context.Err() {
+		return false
+	}
+	if nil != fwd.pty {
+		hijackConn.SetDispatch(fwd.pty.Notify)
+		hijackConn.SetCapture(fwd.opts.capture)
+		hijackConn.SetFrames(fwd.opts.frames)
+	} else {
+		// it may have failed over from a member with a dashboard
+		hijackConn.SetDispatch(nil)
+	}
+	fwd.active.Add(1)
+	select {
+	case fwd.reqChan <- hijackConn:
+		fwd.countConn()
+		return true
+	case <-fwd.context.Done():
+		fwd.active.Add(-1)
+		return false
+	}
 }
 
 func (fwd *forwarder) serve() {
@@ -63,9 +136,9 @@ func (fwd *forwarder) serve() {
 	if nil == ctxReq {
 		return
 	}
-	fwdReq := ctxReq.(*remoteForwardRequest)
+	fwd.fwdReq = ctxReq.(*remoteForwardRequest)
+	fwd.svrConn = fwd.sess.Context().Value(ssh.ContextKeyConn).(*gossh.ServerConn)
 	remoteAddr := fwd.sess.RemoteAddr().String()
-	svrConn := fwd.sess.Context().Value(ssh.ContextKeyConn).(*gossh.ServerConn)
 
 	logger.Info("created forward session", map[string]interface{}{
 		"module":     "session",
@@ -73,25 +146,46 @@ func (fwd *forwarder) serve() {
 		"remoteAddr": remoteAddr,
 	})
 
-	go func() {
-		err := fwd.pty.Start()
-		if err != nil {
-			logger.Error("start pty session", err, map[string]interface{}{
-				"module":     "session",
-				"accessId":   fwd.accessId,
-				"remoteAddr": remoteAddr,
-			})
+	if nil != fwd.pty {
+		if fwd.opts.history > 0 {
+			fwd.pty.SetHistory(fwd.opts.history)
 		}
-	}()
+		fwd.pty.SetReplay(fwd.replay)
+		fwd.pty.SetExport(func(har []byte) (string, error) {
+			return publishExport(fwd.name, fwd.sshCommand(), har)
+		})
+		go fwd.reportTraffic()
+		go func() {
+			err := fwd.pty.Start()
+			if err != nil {
+				logger.Error("start pty session", err, map[string]interface{}{
+					"module":     "session",
+					"accessId":   fwd.accessId,
+					"remoteAddr": remoteAddr,
+				})
+			}
+		}()
+	} else {
+		// headless clients parse the first line to learn the tunnel address
+		fmt.Fprintf(fwd.sess, "https://%s\nhttp://%s\n", fwd.url, fwd.url)
+		for _, url := range fwd.urls[1:] {
+			fmt.Fprintf(fwd.sess, "https://%s\n", url)
+		}
+	}
 
 	for {
 		select {
 		case <-fwd.context.Done():
 			return
 		case <-time.After(time.Second * 30):
-			_, err := fwd.sess.SendRequest("keepalive@openssh.com", true, nil)
-			if err != nil {
-				logger.Warn("Failed to send keepalive request", map[string]interface{}{})
+			if err := fwd.keepalive(); err != nil {
+				logger.Warn("Failed to send keepalive request", map[string]interface{}{
+					"module":   "session",
+					"accessId": fwd.accessId,
+					"error":    err.Error(),
+				})
+				// the peer is gone, release the tunnel name for a reconnecting client
+				return
 			}
 		case facadeConn := <-fwd.reqChan:
 			logger.Debug("open forward channel", map[string]interface{}{
@@ -99,36 +193,322 @@ func (fwd *forwarder) serve() {
 				"accessId":   fwd.accessId,
 				"remoteAddr": remoteAddr,
 			})
-			facadeRequestAddr, facadeRequestPortStr, _ := net.SplitHostPort(facadeConn.RemoteAddr().String())
-			facadePort, _ := strconv.Atoi(facadeRequestPortStr)
-			payload := gossh.Marshal(&remoteForwardChannelData{
-				DestAddr:   fwdReq.BindAddr,
-				DestPort:   fwdReq.BindPort,
-				OriginAddr: facadeRequestAddr,
-				OriginPort: uint32(facadePort),
-			})
-			gosshChan, _, err := svrConn.OpenChannel("forwarded-tcpip", payload)
-			sshChan := wrapChannelConn(fwd.sess, gosshChan)
-
-			if err != nil {
-				logger.Error("open forward channel", err, map[string]interface{}{
-					"module":     "session",
-					"accessId":   fwd.accessId,
-					"remoteAddr": remoteAddr,
-				})
-				if nil != facadeConn {
-					facadeConn.Close()
-				}
-				return
-			}
+			// opening may wait up to channelOpenTimeout, it mustn't hold up the other connections
 			go func() {
-				defer func() {
-					facadeConn.Close()
-					sshChan.Close()
-				}()
-				io.Copy(facadeConn, sshChan)
+				defer fwd.active.Add(-1)
+				startTime := time.Now()
+				sshChan, err := fwd.openChannel(facadeConn.RemoteAddr().String())
+				if err != nil {
+					// the local service is unreachable, not the tunnel, which stays up
+					status, reason := channelFailure(err)
+					if fwd.failover(facadeConn, reason) {
+						return
+					}
+					fwd.fail(facadeConn, status, reason, startTime)
+					return
+				}
+				fwd.pipe(facadeConn, sshChan)
 			}()
-			io.Copy(sshChan, facadeConn)
 		}
 	}
 }
+
+// keepaliveTimeout bounds the wait for the answer to a keepalive
+var keepaliveTimeout = 15 * time.Second
+
+var errKeepaliveTimeout = errors.New("keepalive not answered in time")
+
+// keepalive checks the peer answers, a half dead one is disconnected once keepaliveTimeout passed
+func (fwd *forwarder) keepalive() error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := fwd.sess.SendRequest("keepalive@openssh.com", true, nil)
+		done <- err
+	}()
+	timer := time.NewTimer(keepaliveTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		// closing the connection also ends the request still waiting for an answer
+		fwd.svrConn.Close()
+		return errKeepaliveTimeout
+	}
+}
+
+// failover takes a pool member whose channel open failed out of rotation and hands the
+// connection to another member, falling through those whose session ended meanwhile, it
+// reports whether one took it
+func (fwd *forwarder) failover(facadeConn net.Conn, reason string) bool {
+	if nil == fwd.pool {
+		return false
+	}
+	fwd.pool.drop(fwd, reason)
+	h, ok := facadeConn.(*hijackConn)
+	if !ok {
+		return false
+	}
+	for {
+		// dropped members leave the rotation, so each one is tried once
+		next := fwd.pool.pick("", true)
+		if nil == next || next == fwd {
+			return false
+		}
+		if next.forward(h) {
+			return true
+		}
+		fwd.pool.drop(next, "session ended")
+	}
+}
+
+// channelOpenTimeout bounds how long the client may take to accept a forwarded-tcpip channel
+var channelOpenTimeout = 10 * time.Second
+
+var errOpenTimeout = errors.New("forwarded-tcpip channel not accepted in time")
+
+// channelFailure turns the error of a connection through the tunnel into a gateway status and
+// the reason shown on the dashboard
+func channelFailure(err error) (int, string) {
+	var openErr *gossh.OpenChannelError
+	switch {
+	case errors.Is(err, errOpenTimeout), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "local service didn't accept the connection in time"
+	case errors.As(err, &openErr) && strings.HasPrefix(openErr.Message, "local service"):
+		// the echogy client names the local address
+		return http.StatusBadGateway, openErr.Message
+	case errors.As(err, &openErr) && openErr.Reason == gossh.ConnectionFailed:
+		return http.StatusBadGateway, "local service refused connection: " + openErr.Message
+	case errors.As(err, &openErr):
+		return http.StatusBadGateway, "tunnel rejected the connection: " + openErr.Message
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return http.StatusBadGateway, "local service closed the connection without answering"
+	}
+	return http.StatusBadGateway, "tunnel unavailable: " + err.Error()
+}
+
+// fail answers a facade connection the tunnel couldn't carry with an error page, and shows
+// it on the dashboard with the reason
+func (fwd *forwarder) fail(facadeConn net.Conn, status int, reason string, startTime time.Time) {
+	conn, req := facadeConn, (*http.Request)(nil)
+	if h, ok := facadeConn.(*hijackConn); ok {
+		// around the tracker, the exchange is notified here with its reason
+		conn, req = h.Conn, h.request
+	}
+	page := errorpage.NewPage(status, "")
+	resp := errorPages.Response(req, page)
+	resp.Write(conn)
+	facadeConn.Close()
+	logger.Warn("tunnel failure", map[string]interface{}{
+		"module":    "session",
+		"accessId":  fwd.accessId,
+		"status":    status,
+		"reason":    reason,
+		"requestId": page.RequestID,
+	})
+	fwd.notifyFailure(req, resp, reason, startTime)
+}
+
+func (fwd *forwarder) notifyFailure(req *http.Request, resp *http.Response, reason string, startTime time.Time) {
+	if nil == fwd.pty || nil == req {
+		return
+	}
+	fwd.pty.Notify(&tui.Exchange{
+		Response:  resp,
+		Request:   req,
+		UseTime:   time.Since(startTime).Milliseconds(),
+		StartTime: startTime,
+		Failure:   reason,
+	})
+}
+
+// openChannel opens a forwarded-tcpip channel to the client on behalf of the origin address
+func (fwd *forwarder) openChannel(origin string) (net.Conn, error) {
+	originAddr, originPortStr, _ := net.SplitHostPort(origin)
+	originPort, _ := strconv.Atoi(originPortStr)
+	payload := gossh.Marshal(&remoteForwardChannelData{
+		DestAddr:   fwd.fwdReq.BindAddr,
+		DestPort:   fwd.fwdReq.BindPort,
+		OriginAddr: originAddr,
+		OriginPort: uint32(originPort),
+	})
+	type opened struct {
+		channel gossh.Channel
+		reqs    <-chan *gossh.Request
+		err     error
+	}
+	done := make(chan opened, 1)
+	go func() {
+		channel, reqs, err := fwd.svrConn.OpenChannel("forwarded-tcpip", payload)
+		done <- opened{channel, reqs, err}
+	}()
+	timer := time.NewTimer(channelOpenTimeout)
+	defer timer.Stop()
+	select {
+	case o := <-done:
+		if o.err != nil {
+			return nil, o.err
+		}
+		go gossh.DiscardRequests(o.reqs)
+		return wrapChannelConn(fwd.sess, o.channel), nil
+	case <-timer.C:
+		go func() {
+			// nobody waits for a channel accepted this late
+			if o := <-done; o.err == nil {
+				go gossh.DiscardRequests(o.reqs)
+				o.channel.Close()
+			}
+		}()
+		return nil, errOpenTimeout
+	}
+}
+
+// replayTimeout bounds a replay from the dashboard, response included
+const replayTimeout = 30 * time.Second
+
+// replay sends a request from the dashboard on a fresh channel and notifies the exchange as a replay
+func (fwd *forwarder) replay(req *http.Request) {
+	go func() {
+		origin := req.RemoteAddr
+		if origin == "" {
+			origin = fwd.sess.LocalAddr().String()
+		}
+		conn, err := fwd.openChannel(origin)
+		if err != nil {
+			fwd.pty.Alert(fmt.Errorf("replay: open channel: %w", err))
+			return
+		}
+		defer conn.Close()
+		// a local service that never answers doesn't keep the replay around
+		conn.SetDeadline(time.Now().Add(replayTimeout))
+
+		if fwd.path != "" && fwd.opts.strip {
+			stripPrefix(req, fwd.path)
+		}
+		if fwd.opts.compress {
+			askCompressed(req)
+		}
+		fwd.opts.headers.rewriteRequest(req)
+		var reqBody *capture.Buffer
+		if fwd.opts.capture {
+			reqBody = capture.New(capture.DefaultLimit)
+			req.Body = io.NopCloser(io.TeeReader(req.Body, reqBody))
+		}
+
+		startTime := time.Now()
+		if err := req.Write(conn); err != nil {
+			fwd.pty.Alert(fmt.Errorf("replay: write request: %w", err))
+			return
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil {
+			fwd.pty.Alert(fmt.Errorf("replay: read response: %w", err))
+			return
+		}
+		fwd.opts.headers.rewriteResponse(resp)
+		var respBody *capture.Buffer
+		if fwd.opts.capture {
+			respBody = capture.New(capture.DefaultLimit)
+		}
+		io.Copy(orDiscard(respBody), resp.Body)
+		resp.Body.Close()
+		// the body was read through the chunked decoder, what was captured is the entity itself
+		resp.TransferEncoding = nil
+		req.TransferEncoding = nil
+
+		fwd.pty.Notify(&tui.Exchange{
+			Response:     resp,
+			Request:      req,
+			UseTime:      time.Since(startTime).Milliseconds(),
+			RequestBody:  reqBody,
+			ResponseBody: respBody,
+			Replay:       true,
+			StartTime:    startTime,
+		})
+	}()
+}
+
+// reportTraffic keeps the dashboard byte counters up to date, long lived connections included,
+// the pool figures of a pooled tunnel and the splits sending it traffic
+func (fwd *forwarder) reportTraffic() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var in, out int64
+	var poolSize int
+	var poolShare float64
+	var splitLines string
+	for {
+		select {
+		case <-fwd.context.Done():
+			return
+		case <-ticker.C:
+			if fwd.bytesIn.Load() != in || fwd.bytesOut.Load() != out {
+				in, out = fwd.bytesIn.Load(), fwd.bytesOut.Load()
+				fwd.pty.Traffic(in, out)
+			}
+			if lines := splitsOf(fwd); strings.Join(lines, "\n") != splitLines {
+				splitLines = strings.Join(lines, "\n")
+				fwd.pty.Splits(lines)
+			}
+			if nil == fwd.pool {
+				continue
+			}
+			if size, share := fwd.pool.share(fwd); size != poolSize || share != poolShare {
+				poolSize, poolShare = size, share
+				fwd.pty.Pool(size, share)
+			}
+		}
+	}
+}
+
+// pipe copies data between a facade connection and its forwarded channel until either side closes,
+// counting the bytes each side sent whether or not they were HTTP
+func (fwd *forwarder) pipe(facadeConn net.Conn, sshChan net.Conn) {
+	startTime := time.Now()
+	var in, out atomic.Int64
+	facade := facadeConn
+	if h, ok := facadeConn.(*hijackConn); !ok || nil == h.request || !asksUpgrade(h.request) {
+		// upgraded connections, like websockets, may stay quiet for long
+		facade = newIdleConn(facadeConn, limits.idleTimeout, limits.readHeaderTimeout)
+	}
+	client := countReads(facade, &in, &fwd.bytesIn, &totalBytesIn)
+	tunnel := countReads(sshChan, &out, &fwd.bytesOut, &totalBytesOut)
+
+	var clientDone atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer func() {
+			facadeConn.Close()
+			sshChan.Close()
+			close(done)
+		}()
+		if n, _ := io.Copy(facade, tunnel); n == 0 && !clientDone.Load() {
+			// the local service took the connection and closed it without a byte
+			status, reason := channelFailure(io.EOF)
+			fwd.fail(facadeConn, status, reason, startTime)
+		}
+	}()
+	_, err := io.Copy(sshChan, client)
+	clientDone.Store(true)
+	var slowHead *headTimeoutError
+	switch {
+	case errors.As(err, &slowHead):
+		rejections.headerTimeout.Add(1)
+		facadeConn.Close()
+		sshChan.Close()
+	case idled(err):
+		rejections.idle.Add(1)
+		facadeConn.Close()
+		sshChan.Close()
+	}
+	<-done
+
+	logger.Info("access", map[string]interface{}{
+		"module":     "access",
+		"accessId":   fwd.accessId,
+		"remoteAddr": facadeConn.RemoteAddr().String(),
+		"bytesIn":    in.Load(),
+		"bytesOut":   out.Load(),
+		"duration":   time.Since(startTime).String(),
+	})
+}
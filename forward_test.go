@@ -0,0 +1,51 @@
+package echogy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func TestChannelFailure(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantReason string
+	}{
+		{
+			err:        errOpenTimeout,
+			wantStatus: http.StatusGatewayTimeout,
+			wantReason: "local service didn't accept the connection in time",
+		},
+		{
+			err:        &gossh.OpenChannelError{Reason: gossh.ConnectionFailed, Message: "local service on :3000 refused connection"},
+			wantStatus: http.StatusBadGateway,
+			wantReason: "local service on :3000 refused connection",
+		},
+		{
+			err:        fmt.Errorf("dial: %w", &gossh.OpenChannelError{Reason: gossh.ConnectionFailed, Message: "Connection refused"}),
+			wantStatus: http.StatusBadGateway,
+			wantReason: "local service refused connection: Connection refused",
+		},
+		{
+			err:        io.EOF,
+			wantStatus: http.StatusBadGateway,
+			wantReason: "local service closed the connection without answering",
+		},
+		{
+			err:        errors.New("ssh: disconnected"),
+			wantStatus: http.StatusBadGateway,
+			wantReason: "tunnel unavailable: ssh: disconnected",
+		},
+	}
+	for _, tt := range tests {
+		status, reason := channelFailure(tt.err)
+		if status != tt.wantStatus || reason != tt.wantReason {
+			t.Errorf("channelFailure(%v) = %d %q, want %d %q", tt.err, status, reason, tt.wantStatus, tt.wantReason)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package echogy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// header rule operations
+const (
+	headerSet = "set"
+	headerAdd = "add"
+	headerDel = "del"
+)
+
+type headerRule struct {
+	op    string
+	name  string
+	value string
+}
+
+// headerRules rewrite the requests sent through a tunnel and the responses coming back,
+// set with the req-set, req-add, req-del, host, res-set and res-del options
+type headerRules struct {
+	request  []headerRule
+	response []headerRule
+	host     string // Host sent to the local service, e.g. localhost:3000, unchanged when empty
+}
+
+// hopHeaders belong to the connection rather than the request, they can't be rewritten,
+// nor can Host, which has the host option
+var hopHeaders = []string{"Connection", "Keep-Alive", "Transfer-Encoding", "Content-Length", "Upgrade", "Te", "Trailer", "Host"}
+
+// parseHeaderRule parses the value of a header option, Name:value, or Name alone to remove it
+func parseHeaderRule(key, op, value string) (headerRule, error) {
+	name, v, hasValue := strings.Cut(value, ":")
+	name, v = strings.TrimSpace(name), strings.TrimSpace(v)
+	switch {
+	case !httpguts.ValidHeaderFieldName(name):
+		return headerRule{}, fmt.Errorf("option %s: %q is not a header name", key, name)
+	case op != headerDel && !hasValue:
+		return headerRule{}, fmt.Errorf("option %s: %q must be Name:value", key, value)
+	case op == headerDel && hasValue:
+		return headerRule{}, fmt.Errorf("option %s: %q must be a header name alone", key, value)
+	case !httpguts.ValidHeaderFieldValue(v):
+		return headerRule{}, fmt.Errorf("option %s: %q is not a header value", key, v)
+	}
+	name = http.CanonicalHeaderKey(name)
+	for _, hop := range hopHeaders {
+		if name == hop {
+			return headerRule{}, fmt.Errorf("option %s: %s can't be rewritten", key, name)
+		}
+	}
+	return headerRule{op: op, name: name, value: v}, nil
+}
+
+// parse adds the rule of a header option
+func (r *headerRules) parse(key, value string) error {
+	if key == "host" {
+		if value == "" || !httpguts.ValidHostHeader(value) {
+			return fmt.Errorf("option %s: %q is not a host", key, value)
+		}
+		r.host = value
+		return nil
+	}
+	side, op, _ := strings.Cut(key, "-")
+	rule, err := parseHeaderRule(key, op, value)
+	if err != nil {
+		return err
+	}
+	if side == "req" {
+		r.request = append(r.request, rule)
+	} else {
+		r.response = append(r.response, rule)
+	}
+	return nil
+}
+
+func (r *headerRules) empty() bool {
+	return len(r.request) == 0 && len(r.response) == 0 && r.host == ""
+}
+
+func applyHeaderRules(header http.Header, rules []headerRule) {
+	for _, rule := range rules {
+		switch rule.op {
+		case headerSet:
+			header.Set(rule.name, rule.value)
+		case headerAdd:
+			header.Add(rule.name, rule.value)
+		case headerDel:
+			header.Del(rule.name)
+		}
+	}
+}
+
+// rewriteRequest applies the request rules to a request about to be sent through the tunnel
+func (r *headerRules) rewriteRequest(req *http.Request) {
+	applyHeaderRules(req.Header, r.request)
+	if r.host != "" {
+		req.Host = r.host
+	}
+}
+
+// rewriteResponse applies the response rules to a response coming back from the tunnel
+func (r *headerRules) rewriteResponse(resp *http.Response) {
+	applyHeaderRules(resp.Header, r.response)
+}
@@ -0,0 +1,96 @@
+package echogy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestHeaderRulesParse(t *testing.T) {
+	tests := []struct {
+		key     string
+		value   string
+		wantErr bool
+	}{
+		{key: "req-set", value: "X-Team: web"},
+		{key: "req-add", value: "x-tag:a"},
+		{key: "req-del", value: "Cookie"},
+		{key: "res-set", value: "Cache-Control:no-store"},
+		{key: "res-del", value: "Server"},
+		{key: "host", value: "localhost:3000"},
+		{key: "req-set", value: "X-Team", wantErr: true},
+		{key: "req-del", value: "X-Team:web", wantErr: true},
+		{key: "req-set", value: "Bad Name:web", wantErr: true},
+		{key: "req-set", value: "Connection:close", wantErr: true},
+		{key: "req-set", value: "host:localhost", wantErr: true},
+		{key: "res-del", value: "Transfer-Encoding", wantErr: true},
+		{key: "host", value: "", wantErr: true},
+		{key: "host", value: "local host", wantErr: true},
+	}
+	for _, tt := range tests {
+		var rules headerRules
+		err := rules.parse(tt.key, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parse(%s=%s) error = %v, wantErr %v", tt.key, tt.value, err, tt.wantErr)
+		}
+	}
+
+	var rules headerRules
+	rules.parse("req-set", "x-team: web")
+	rules.parse("res-del", "server")
+	if rules.request[0] != (headerRule{op: headerSet, name: "X-Team", value: "web"}) ||
+		rules.response[0] != (headerRule{op: headerDel, name: "Server"}) {
+		t.Errorf("parse() = %+v", rules)
+	}
+}
+
+func TestFacadeHeaderRules(t *testing.T) {
+	fwd, closeTunnel := backendTunnel("vite", "", false, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "vite")
+		w.Header().Set("Cache-Control", "max-age=60")
+		io.WriteString(w, r.Host+" "+r.Header.Get("X-Team")+" "+r.Header.Get("Cookie"))
+	})
+	defer closeTunnel()
+	for _, arg := range [][2]string{{"host", "localhost:5173"}, {"req-set", "X-Team:web"}, {"req-del", "Cookie"},
+		{"res-set", "Cache-Control:no-store"}, {"res-del", "Server"}} {
+		if err := fwd.opts.headers.parse(arg[0], arg[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the first request of a connection is read by the facade before the tunnel is known,
+	// it is rewritten as well as the ones following it
+	dials := 0
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			dials++
+			c, s := net.Pipe()
+			go handleConnection(s, newHostRouter(1, "webs.sh"), func(string, string, *hijackConn) bool {
+				t.Error("a tunnel rewriting headers was piped")
+				return false
+			})
+			return c, nil
+		},
+	}}
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, "http://vite.webs.sh/", nil)
+		req.Header.Set("Cookie", "session=1")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if want := "localhost:5173 web "; string(body) != want {
+			t.Errorf("request %d reached the service as %q, want %q", i, body, want)
+		}
+		if resp.Header.Get("Server") != "" || resp.Header.Get("Cache-Control") != "no-store" {
+			t.Errorf("request %d response headers = %v", i, resp.Header)
+		}
+	}
+	if dials != 1 {
+		t.Errorf("%d connections to the facade, the requests should share one", dials)
+	}
+}
@@ -1,80 +1,160 @@
 package echogy
 
 import (
-	"bufio"
-	"bytes"
-	q "github.com/youkale/echogy/pkg/queue"
+	"io"
 	"net"
 	"net/http"
-	"time"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/youkale/echogy/pkg/capture"
+	"github.com/youkale/echogy/pkg/httpstream"
+	"github.com/youkale/echogy/pkg/wsframe"
+	"github.com/youkale/echogy/tui"
 )
 
-type Dispatch func(*http.Response, *http.Request, int64)
+type Dispatch func(*tui.Exchange)
 
+// hijackConn observes the HTTP exchanges of a facade connection, requests are read
+// from the client and responses written back to it
 type hijackConn struct {
 	net.Conn
 	dispatch Dispatch
-	q        *q.SyncQueue
+	capture  bool
+	frames   bool
+	once     sync.Once
+	tracker  *httpstream.Tracker // nil until the first byte, and when nobody listens
+	stream   *tui.Stream         // set by the tracker once the connection switched protocols
+	request  *http.Request       // the first request, as read by the facade
 }
 
-type request struct {
-	*http.Request
-	startTime int64
+// asksUpgrade reports whether the connection may switch protocols after req
+func asksUpgrade(req *http.Request) bool {
+	return req.Method == http.MethodConnect || req.Header.Get("Upgrade") != ""
 }
 
 func newHijackConn(conn net.Conn) *hijackConn {
 	return &hijackConn{
 		Conn: conn,
-		q:    q.NewSyncQueue(16),
 	}
 }
 
-func (h *hijackConn) AddRequest(r *http.Request) {
-	h.q.Push(&request{
-		Request:   r,
-		startTime: time.Now().UnixMilli(),
+// track returns the tracker of the connection, started on first use once dispatch is set
+func (h *hijackConn) track() *httpstream.Tracker {
+	h.once.Do(func() {
+		if nil == h.dispatch {
+			return
+		}
+		limit := -1
+		if h.capture {
+			limit = capture.DefaultLimit
+		}
+		remoteAddr := h.RemoteAddr().String()
+		h.tracker = httpstream.NewTracker(limit, func(e *httpstream.Exchange) {
+			e.Request.RemoteAddr = remoteAddr
+			exchange := &tui.Exchange{
+				Response:     e.Response,
+				Request:      e.Request,
+				UseTime:      e.Duration().Milliseconds(),
+				RequestBody:  e.RequestBody,
+				ResponseBody: e.ResponseBody,
+				StartTime:    e.Start,
+			}
+			if e.Switched() {
+				h.stream = tui.NewStream(upgradeProtocol(e.Request, e.Response), h.frames)
+				exchange.Stream = h.stream
+			}
+			h.dispatch(exchange)
+		})
+		h.tracker.OnUpgrade = h.follow
 	})
+	return h.tracker
+}
+
+// upgradeProtocol names what the connection carries after switching protocols
+func upgradeProtocol(req *http.Request, resp *http.Response) string {
+	if req.Method == http.MethodConnect {
+		return "tunnel"
+	}
+	if protocol := resp.Header.Get("Upgrade"); protocol != "" {
+		return strings.ToLower(protocol)
+	}
+	return "unknown"
+}
+
+// follow counts what both directions carry after an upgrade, the stream closes once both ended
+func (h *hijackConn) follow(e *httpstream.Exchange) (client, server httpstream.Upgraded) {
+	stream := h.stream
+	var open atomic.Int32
+	open.Store(2)
+	direction := func(fromClient bool) httpstream.Upgraded {
+		return func(r io.Reader) {
+			defer func() {
+				if open.Add(-1) == 0 {
+					stream.Close()
+				}
+			}()
+			if stream.Protocol == "websocket" {
+				for {
+					f, err := wsframe.ReadFrame(r, tui.FramePreview)
+					if err != nil {
+						break
+					}
+					stream.Frame(fromClient, f)
+				}
+			}
+			// whatever isn't framed, or is no longer, is still counted
+			io.Copy(&streamCounter{stream: stream, fromClient: fromClient}, r)
+		}
+	}
+	return direction(true), direction(false)
+}
+
+// streamCounter counts bytes written to it on a stream
+type streamCounter struct {
+	stream     *tui.Stream
+	fromClient bool
+}
+
+func (s *streamCounter) Write(b []byte) (int, error) {
+	s.stream.Bytes(s.fromClient, int64(len(b)))
+	return len(b), nil
 }
 
 func (h *hijackConn) Read(b []byte) (n int, err error) {
 	n, err = h.Conn.Read(b)
-	if err != nil {
-		return n, err
+	if t := h.track(); n > 0 && nil != t {
+		t.Request(b[:n])
 	}
+	return n, err
+}
 
-	// Try to parse HTTP request from the data
-	reader := bytes.NewReader(b[:n])
-	if req, err := http.ReadRequest(bufio.NewReader(reader)); err == nil {
-		// add req to queue
-		h.q.Push(&request{
-			Request:   req,
-			startTime: time.Now().UnixMilli(),
-		})
+func (h *hijackConn) Write(b []byte) (n int, err error) {
+	n, err = h.Conn.Write(b)
+	if t := h.track(); n > 0 && nil != t {
+		t.Response(b[:n])
 	}
-	return n, nil
+	return n, err
 }
 
 func (h *hijackConn) SetDispatch(d Dispatch) {
 	h.dispatch = d
 }
 
-func (h *hijackConn) Write(b []byte) (n int, err error) {
-	n, err = h.Conn.Write(b)
-	if nil != err {
-		return n, err
-	}
+// SetCapture enables keeping request and response bodies in the dispatched exchanges
+func (h *hijackConn) SetCapture(enabled bool) {
+	h.capture = enabled
+}
 
-	pop := h.q.Pop()
-	if nil != pop {
-		r := pop.(*request)
-		// pop request from request queue and Try to parse HTTP response from the data
-		if nil != h.dispatch {
-			reader := bytes.NewReader(b)
-			if resp, err := http.ReadResponse(bufio.NewReader(reader), r.Request); nil == err {
-				useTime := time.Now().UnixMilli() - r.startTime
-				h.dispatch(resp, r.Request, useTime)
-			}
-		}
+// SetFrames enables keeping websocket frames for the frame viewer
+func (h *hijackConn) SetFrames(enabled bool) {
+	h.frames = enabled
+}
+
+func (h *hijackConn) Close() error {
+	if t := h.track(); nil != t {
+		t.Close()
 	}
-	return n, nil
+	return h.Conn.Close()
 }
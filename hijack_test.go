@@ -0,0 +1,58 @@
+package echogy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/youkale/echogy/tui"
+)
+
+func TestHijackWebSocket(t *testing.T) {
+	client, server := net.Pipe()
+	h := newHijackConn(server)
+	exchanges := make(chan *tui.Exchange, 4)
+	h.SetDispatch(func(e *tui.Exchange) { exchanges <- e })
+	h.SetFrames(true)
+
+	go func() {
+		client.Write([]byte("GET /chat HTTP/1.1\r\nHost: a.webs.sh\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+		// a masked "hi" from the client, then a close
+		client.Write([]byte{0x81, 0x82, 1, 2, 3, 4, 'h' ^ 1, 'i' ^ 2})
+		client.Write([]byte{0x88, 0x80, 0, 0, 0, 0})
+		io.Copy(io.Discard, client)
+	}()
+	buf := make([]byte, 512)
+	for i := 0; i < 3; i++ {
+		if _, err := h.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"))
+	h.Write([]byte{0x81, 0x05, 'h', 'e', 'l', 'l', 'o', 0x82, 0x01, 0xFF})
+
+	var e *tui.Exchange
+	select {
+	case e = <-exchanges:
+	case <-time.After(time.Second):
+		t.Fatal("the handshake wasn't dispatched")
+	}
+	if e.StatusCode != 101 || e.Stream == nil || e.Stream.Protocol != "websocket" {
+		t.Fatalf("exchange = %d %v", e.StatusCode, e.Stream)
+	}
+	// frames that look like HTTP mustn't start a new exchange
+	h.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+	h.Close()
+	time.Sleep(20 * time.Millisecond)
+
+	if e.Stream.Live() {
+		t.Error("stream still live after close")
+	}
+	if len(exchanges) != 0 {
+		t.Errorf("%d more exchanges after the upgrade", len(exchanges))
+	}
+	if fromClient, fromServer := e.Stream.Messages(); fromClient != 1 || fromServer != 2 {
+		t.Errorf("messages = %d from the client, %d from the server", fromClient, fromServer)
+	}
+}
@@ -0,0 +1,306 @@
+package echogy
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// facadeLimits keep slow or oversized clients from holding facade connections
+type facadeLimits struct {
+	readHeaderTimeout time.Duration // to read the request line and headers
+	maxHeaderBytes    int           // request line and headers
+	maxRequestLine    int
+	idleTimeout       time.Duration // a keep-alive connection carrying nothing either way is closed
+}
+
+var limits = facadeLimits{
+	readHeaderTimeout: 10 * time.Second,
+	maxHeaderBytes:    1 << 20,
+	maxRequestLine:    8 << 10,
+	idleTimeout:       2 * time.Minute,
+}
+
+// configureLimits replaces the defaults, zero values keep them, it must run before the facade serves
+func configureLimits(l facadeLimits) {
+	if l.readHeaderTimeout > 0 {
+		limits.readHeaderTimeout = l.readHeaderTimeout
+	}
+	if l.maxHeaderBytes > 0 {
+		limits.maxHeaderBytes = l.maxHeaderBytes
+	}
+	if l.maxRequestLine > 0 {
+		limits.maxRequestLine = l.maxRequestLine
+	}
+	if l.idleTimeout > 0 {
+		limits.idleTimeout = l.idleTimeout
+	}
+	h2Server.IdleTimeout = limits.idleTimeout
+}
+
+// rejections counts the facade connections ended by the limits, by reason
+var rejections = struct {
+	headerTimeout, headerTooLarge, requestLineTooLong, idle atomic.Int64
+}{}
+
+var errHeaderTooLarge = errors.New("request header too large")
+
+// headLimit reads up to n bytes, then fails with errHeaderTooLarge
+type headLimit struct {
+	r io.Reader
+	n int
+}
+
+func (l *headLimit) Read(b []byte) (int, error) {
+	if l.n <= 0 {
+		return 0, errHeaderTooLarge
+	}
+	if len(b) > l.n {
+		b = b[:l.n]
+	}
+	n, err := l.r.Read(b)
+	l.n -= n
+	return n, err
+}
+
+// readRequestHead reads the request line and headers of a facade connection within the limits,
+// on a violation it answers with the matching error page and returns a nil request
+func readRequestHead(c net.Conn, reader *bufferedReader) (*http.Request, error) {
+	c.SetReadDeadline(time.Now().Add(limits.readHeaderTimeout))
+	// like net/http, the bufio reader may read ahead into the body
+	req, err := http.ReadRequest(bufio.NewReader(&headLimit{r: reader, n: limits.maxHeaderBytes + 4096}))
+	if err == nil {
+		c.SetReadDeadline(time.Time{})
+		if requestLine := len(req.Method) + len(req.RequestURI) + len(req.Proto) + 2; requestLine <= limits.maxRequestLine {
+			return req, nil
+		}
+	}
+
+	line := reader.Bytes()
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+	var netErr net.Error
+	switch {
+	case len(line) > limits.maxRequestLine:
+		rejections.requestLineTooLong.Add(1)
+		return nil, rejected(c, req, http.StatusRequestURITooLong, err)
+	case errors.Is(err, errHeaderTooLarge):
+		rejections.headerTooLarge.Add(1)
+		return nil, rejected(c, nil, http.StatusRequestHeaderFieldsTooLarge, err)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		rejections.headerTimeout.Add(1)
+		return nil, rejected(c, nil, http.StatusRequestTimeout, err)
+	}
+	return nil, rejected(c, nil, http.StatusBadRequest, err)
+}
+
+type rejection struct {
+	status    int
+	requestId string
+	err       error
+}
+
+func (r *rejection) Error() string {
+	if nil == r.err {
+		return http.StatusText(r.status)
+	}
+	return r.err.Error()
+}
+
+func rejected(c net.Conn, req *http.Request, status int, err error) error {
+	c.SetWriteDeadline(time.Now().Add(limits.readHeaderTimeout))
+	return &rejection{status: status, requestId: errorPage(c, req, status, ""), err: err}
+}
+
+// idleConn is the facade side of a piped connection, reading from it fails once nothing
+// went either way for the idle timeout, or once a request head took longer than the header
+// timeout, which serveHTTP gets from http.Server.ReadHeaderTimeout
+type idleConn struct {
+	net.Conn
+	timeout     time.Duration
+	headTimeout time.Duration
+	active      atomic.Int64 // unix nanoseconds of the last byte either way
+	heads       requestHeads // only read by Read
+}
+
+func newIdleConn(conn net.Conn, timeout, headTimeout time.Duration) *idleConn {
+	c := &idleConn{Conn: conn, timeout: timeout, headTimeout: headTimeout}
+	c.touch()
+	return c
+}
+
+func (c *idleConn) touch() {
+	c.active.Store(time.Now().UnixNano())
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	for {
+		deadline := time.Unix(0, c.active.Load()).Add(c.timeout)
+		headDeadline, reading := c.heads.deadline(c.headTimeout)
+		if reading && headDeadline.Before(deadline) {
+			deadline = headDeadline
+		}
+		c.Conn.SetReadDeadline(deadline)
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			c.touch()
+			c.heads.feed(b[:n])
+		}
+		var netErr net.Error
+		if n == 0 && errors.As(err, &netErr) && netErr.Timeout() {
+			if reading && !time.Now().Before(headDeadline) {
+				return n, &headTimeoutError{err}
+			}
+			if time.Since(time.Unix(0, c.active.Load())) < c.timeout {
+				// the tunnel side was busy meanwhile, a response may take its time
+				continue
+			}
+		}
+		return n, err
+	}
+}
+
+func (c *idleConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.touch()
+	return n, err
+}
+
+// headTimeoutError ends a piped connection whose client was too slow sending a request head
+type headTimeoutError struct {
+	err error
+}
+
+func (e *headTimeoutError) Error() string {
+	return "request head not received in time: " + e.err.Error()
+}
+
+func (e *headTimeoutError) Unwrap() error {
+	return e.err
+}
+
+// idled reports whether err ended the connection for being idle
+func idled(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// states of requestHeads
+const (
+	inHead = iota
+	inBody
+	inChunkSize
+	inChunk
+	inTrailer
+	notFollowed // the connection no longer carries requests that can be followed
+)
+
+// requestHeads follows the requests a client sends on a piped connection, to know when a
+// request head is being read
+type requestHeads struct {
+	state   int
+	head    []byte    // the head read so far
+	line    []byte    // the chunk size or trailer line read so far
+	remain  int64     // bytes left of the body, or of the chunk and its CRLF
+	started time.Time // first byte of the head being read, zero between heads
+}
+
+// deadline returns when the head being read times out, false when none is being read
+func (h *requestHeads) deadline(timeout time.Duration) (time.Time, bool) {
+	if h.started.IsZero() {
+		return time.Time{}, false
+	}
+	return h.started.Add(timeout), true
+}
+
+// feed follows the bytes a client sent
+func (h *requestHeads) feed(b []byte) {
+	for len(b) > 0 {
+		switch h.state {
+		case inHead:
+			if h.started.IsZero() {
+				h.started = time.Now()
+			}
+			searched := max(len(h.head)-3, 0)
+			h.head = append(h.head, b...)
+			end := bytes.Index(h.head[searched:], []byte("\r\n\r\n"))
+			if end < 0 {
+				if len(h.head) > limits.maxHeaderBytes+4096 {
+					// too large to be served, the head keeps timing out
+					h.head, h.state = nil, notFollowed
+				}
+				return
+			}
+			end += searched + 4
+			b = h.head[end:]
+			h.bodyOf(h.head[:end])
+			h.head, h.started = nil, time.Time{}
+		case inBody, inChunk:
+			n := min(int64(len(b)), h.remain)
+			b, h.remain = b[n:], h.remain-n
+			if h.remain > 0 {
+				return
+			}
+			if h.state == inBody {
+				h.state = inHead
+			} else {
+				h.state = inChunkSize
+			}
+		case inChunkSize, inTrailer:
+			i := bytes.IndexByte(b, '\n')
+			if i < 0 {
+				h.line = append(h.line, b...)
+				if len(h.line) > 4096 {
+					h.state = notFollowed
+				}
+				return
+			}
+			line := strings.TrimSpace(string(append(h.line, b[:i]...)))
+			b, h.line = b[i+1:], nil
+			if h.state == inTrailer {
+				if line == "" {
+					h.state = inHead
+				}
+				continue
+			}
+			sizeField, _, _ := strings.Cut(line, ";")
+			size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+			switch {
+			case err != nil || size < 0:
+				h.state = notFollowed
+			case size == 0:
+				h.state = inTrailer
+			default:
+				// the chunk and the CRLF closing it
+				h.state, h.remain = inChunk, size+2
+			}
+		case notFollowed:
+			return
+		}
+	}
+}
+
+// bodyOf finds out from a request head how its body is framed
+func (h *requestHeads) bodyOf(head []byte) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+	switch {
+	case err != nil, asksUpgrade(req):
+		// what follows is up to the local service
+		h.state = notFollowed
+	case slices.Contains(req.TransferEncoding, "chunked"):
+		h.state = inChunkSize
+	case req.ContentLength > 0:
+		h.state, h.remain = inBody, req.ContentLength
+	default:
+		h.state = inHead
+	}
+}
@@ -0,0 +1,117 @@
+package echogy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRequestHead(t *testing.T) {
+	saved := limits
+	defer func() { limits = saved }()
+	limits.readHeaderTimeout = 100 * time.Millisecond
+	limits.maxHeaderBytes = 1024
+	limits.maxRequestLine = 64
+
+	tests := []struct {
+		name       string
+		request    string
+		wantStatus int // 0 for a request read
+	}{
+		{"ok", "GET / HTTP/1.1\r\nHost: a.webs.sh\r\n\r\n", 0},
+		{"slow headers", "GET / HTTP/1.1\r\nHost: a.webs.sh\r\n", http.StatusRequestTimeout},
+		{"long request line", "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\nHost: a.webs.sh\r\n\r\n", http.StatusRequestURITooLong},
+		{"unfinished long request line", "GET /" + strings.Repeat("a", 2000), http.StatusRequestURITooLong},
+		{"large headers", "GET / HTTP/1.1\r\nHost: a.webs.sh\r\nX-Big: " + strings.Repeat("b", 8000) + "\r\n\r\n", http.StatusRequestHeaderFieldsTooLarge},
+		{"garbage", "not http at all\r\n\r\n", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go client.Write([]byte(tt.request))
+			responses := make(chan *http.Response, 1)
+			go func() {
+				// the pipe stalls a write until it is read, read the answer in parallel
+				resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+				if err == nil {
+					io.ReadAll(resp.Body)
+				}
+				responses <- resp
+			}()
+
+			req, err := readRequestHead(server, newBufferedReader(server))
+			if tt.wantStatus == 0 {
+				if err != nil || nil == req || req.Host != "a.webs.sh" {
+					t.Fatalf("readRequestHead() = %v, %v", req, err)
+				}
+				return
+			}
+			r, ok := err.(*rejection)
+			if !ok || r.status != tt.wantStatus {
+				t.Fatalf("readRequestHead() error = %v, want status %d", err, tt.wantStatus)
+			}
+			if resp := <-responses; nil == resp || resp.StatusCode != tt.wantStatus {
+				t.Errorf("response = %v, want %d", resp, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestRequestHeads(t *testing.T) {
+	tests := []struct {
+		name        string
+		chunks      []string
+		wantReading bool
+		wantState   int
+	}{
+		{"between requests", []string{"GET / HTTP/1.1\r\nHost: a\r\n\r\n"}, false, inHead},
+		{"head split across reads", []string{"GET / HTTP/1.1\r\nHo", "st: a\r", "\n\r\n"}, false, inHead},
+		{"unfinished head", []string{"GET / HTTP/1.1\r\nHost: a\r\n"}, true, inHead},
+		{"sized body", []string{"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhel"}, false, inBody},
+		{"head after a sized body", []string{"POST / HTTP/1.1\r\nHost: a\r\nContent-Length: 5\r\n\r\nhello", "GET / HT"}, true, inHead},
+		{"chunked body", []string{"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n", "5\r\nhel", "lo\r\n0\r\n\r\n"}, false, inHead},
+		{"head after a chunked body", []string{"POST / HTTP/1.1\r\nHost: a\r\nTransfer-Encoding: chunked\r\n\r\n3;x=y\r\nabc\r\n0\r\nX-Trailer: 1\r\n\r\nGET /"}, true, inHead},
+		{"upgrade", []string{"GET / HTTP/1.1\r\nHost: a\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n", "GET / HT"}, false, notFollowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			heads := &requestHeads{}
+			for _, chunk := range tt.chunks {
+				heads.feed([]byte(chunk))
+			}
+			if _, reading := heads.deadline(time.Second); reading != tt.wantReading || heads.state != tt.wantState {
+				t.Errorf("reading = %v, state %d, want %v, %d", reading, heads.state, tt.wantReading, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestIdleConnHeadTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := newIdleConn(server, time.Minute, 50*time.Millisecond)
+
+	go func() {
+		// a first request, then the head of the next one trickles in
+		io.WriteString(client, "GET / HTTP/1.1\r\nHost: a.webs.sh\r\n\r\n")
+		io.WriteString(client, "GET / HTTP/1.1\r\n")
+		for range 20 {
+			time.Sleep(10 * time.Millisecond)
+			if _, err := io.WriteString(client, "X"); err != nil {
+				return
+			}
+		}
+	}()
+	_, err := io.Copy(io.Discard, conn)
+	var slowHead *headTimeoutError
+	if !errors.As(err, &slowHead) {
+		t.Errorf("piped copy ended with %v, want the head timing out", err)
+	}
+}
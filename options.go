@@ -0,0 +1,147 @@
+package echogy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/youkale/echogy/tui"
+)
+
+// tunnelOptions are per tunnel settings passed as key=value arguments of the ssh command,
+// e.g. `ssh -t -R 80:localhost:3000 webs.sh capture=off`
+type tunnelOptions struct {
+	capture  bool        // keep request and response bodies for the inspector
+	history  int         // exchanges kept by the dashboard, 0 keeps its default
+	frames   bool        // keep websocket frames for the frame viewer
+	h2       bool        // the local service speaks h2c, proxied requests are sent as HTTP/2
+	domain   string      // custom domain served by the tunnel, once verified
+	pool     string      // balance policy of the pool the tunnel joins, sessions claiming a taken name fail when empty
+	key      string      // secret proving the session may join the pool of the name, or take the traffic of a split
+	path     string      // path prefix the tunnel serves under its name, empty for every path
+	strip    bool        // remove the path prefix before forwarding
+	headers  headerRules // rewrite the requests sent through the tunnel and its responses
+	cors     corsPolicy  // lets pages of other origins call the tunnel
+	compress bool        // ask the local service for compressed responses, decoded at the edge for clients without support
+}
+
+// configuredOptions holds the options of the config by tunnel name, see Options.TunnelOptions
+var configuredOptions map[string][]string
+
+func defaultTunnelOptions() *tunnelOptions {
+	return &tunnelOptions{
+		capture: true,
+	}
+}
+
+func parseBool(key, value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "on", "yes", "true", "1":
+		return true, nil
+	case "off", "no", "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("option %s: %q is not a boolean, use on or off", key, value)
+}
+
+func parsePool(key, value string) (string, error) {
+	switch strings.ToLower(value) {
+	case "on", roundRobin:
+		return roundRobin, nil
+	case leastConn:
+		return leastConn, nil
+	}
+	return "", fmt.Errorf("option %s: %q is not a balance policy, use %s or %s", key, value, roundRobin, leastConn)
+}
+
+// minKeyLength keeps the secrets of the key option from being guessed
+const minKeyLength = 8
+
+func parseKey(key, value string) (string, error) {
+	if len(value) < minKeyLength {
+		return "", fmt.Errorf("option %s: a key needs at least %d characters", key, minKeyLength)
+	}
+	return value, nil
+}
+
+func parseHistory(key, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > tui.MaxRequestHistory {
+		return 0, fmt.Errorf("option %s: %q must be a number between 1 and %d", key, value, tui.MaxRequestHistory)
+	}
+	return n, nil
+}
+
+// parseTunnelOptions parses the arguments of the ssh session command
+func parseTunnelOptions(args []string) (*tunnelOptions, error) {
+	opts := defaultTunnelOptions()
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("option %q must be in key=value form", arg)
+		}
+		var err error
+		switch strings.ToLower(key) {
+		case "capture":
+			opts.capture, err = parseBool(key, value)
+		case "frames":
+			opts.frames, err = parseBool(key, value)
+		case "compress":
+			opts.compress, err = parseBool(key, value)
+		case "h2":
+			opts.h2, err = parseBool(key, value)
+		case "domain":
+			opts.domain = normalizeHost(value)
+		case "pool":
+			opts.pool, err = parsePool(key, value)
+		case "key":
+			opts.key, err = parseKey(key, value)
+		case "path":
+			opts.path, err = normalizePathPrefix(key, value)
+		case "strip":
+			opts.strip, err = parseBool(key, value)
+		case "req-set", "req-add", "req-del", "res-set", "res-del", "host":
+			err = opts.headers.parse(strings.ToLower(key), value)
+		case "cors", "cors-origins", "cors-credentials", "cors-methods", "cors-headers":
+			err = opts.cors.parse(strings.ToLower(key), value)
+		case "history":
+			opts.history, err = parseHistory(key, value)
+		default:
+			return nil, fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opts.pool != "" && opts.key == "" {
+		return nil, fmt.Errorf("option pool needs a key shared by the sessions of the pool, e.g. key=<secret>")
+	}
+	if opts.domain != "" && opts.key == "" {
+		return nil, fmt.Errorf("option domain needs a key, the proof of the domain is made for it, e.g. key=<secret>")
+	}
+	if opts.strip && opts.path == "" {
+		return nil, fmt.Errorf("option strip needs a path, e.g. path=/api")
+	}
+	if opts.cors.configured() && !opts.cors.enabled {
+		return nil, fmt.Errorf("options cors-origins, cors-credentials, cors-methods and cors-headers need cors=on")
+	}
+	if opts.cors.credentials && len(opts.cors.origins) == 0 {
+		return nil, fmt.Errorf("option cors-credentials needs cors-origins, any origin can't send cookies")
+	}
+	return opts, nil
+}
+
+// perRequest reports whether the tunnel needs to see each request, its connections are then
+// served through tunnelHandler instead of being piped
+func (opts *tunnelOptions) perRequest() bool {
+	return !opts.headers.empty() || opts.cors.enabled || opts.compress
+}
+
+// ValidateTunnelOptions checks the default options of a tunnel name set in the config
+func ValidateTunnelOptions(name string, args []string) error {
+	if !validAccessId(name) {
+		return fmt.Errorf("%q is not a tunnel name", name)
+	}
+	_, err := parseTunnelOptions(args)
+	return err
+}
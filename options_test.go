@@ -0,0 +1,53 @@
+package echogy
+
+import "testing"
+
+func TestParseTunnelOptions(t *testing.T) {
+	tests := []struct {
+		args        []string
+		wantCapture bool
+		wantHistory int
+		wantH2      bool
+		wantDomain  string
+		wantPool    string
+		wantPath    string
+		wantStrip   bool
+		wantErr     bool
+	}{
+		{args: nil, wantCapture: true},
+		{args: []string{"capture=off"}, wantCapture: false},
+		{args: []string{"history=200", "capture=on"}, wantCapture: true, wantHistory: 200},
+		{args: []string{"h2=on"}, wantCapture: true, wantH2: true},
+		{args: []string{"domain=API.Customer.com", "key=team-secret"}, wantCapture: true, wantDomain: "api.customer.com"},
+		{args: []string{"domain=api.customer.com"}, wantErr: true},
+		{args: []string{"pool=on", "key=team-secret"}, wantCapture: true, wantPool: roundRobin},
+		{args: []string{"pool=Least-Conn", "key=team-secret"}, wantCapture: true, wantPool: leastConn},
+		{args: []string{"pool=on"}, wantErr: true},
+		{args: []string{"pool=on", "key=short"}, wantErr: true},
+		{args: []string{"pool=random"}, wantErr: true},
+		{args: []string{"path=/api/*", "strip=on"}, wantCapture: true, wantPath: "/api", wantStrip: true},
+		{args: []string{"strip=on"}, wantErr: true},
+		{args: []string{"path=api"}, wantErr: true},
+		{args: []string{"req-set=X-Team:web", "host=localhost:3000", "res-del=Server"}, wantCapture: true},
+		{args: []string{"req-set=X-Team"}, wantErr: true},
+		{args: []string{"history=0"}, wantErr: true},
+		{args: []string{"history=1000"}, wantErr: true},
+		{args: []string{"history=many"}, wantErr: true},
+		{args: []string{"capture"}, wantErr: true},
+		{args: []string{"colour=on"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		opts, err := parseTunnelOptions(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTunnelOptions(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if opts.capture != tt.wantCapture || opts.history != tt.wantHistory || opts.h2 != tt.wantH2 || opts.domain != tt.wantDomain ||
+			opts.pool != tt.wantPool || opts.path != tt.wantPath || opts.strip != tt.wantStrip {
+			t.Errorf("parseTunnelOptions(%q) = %+v", tt.args, *opts)
+		}
+	}
+}
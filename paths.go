@@ -0,0 +1,185 @@
+package echogy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Tunnels opened with the path option share a name with other tunnels, each serving the
+// requests under its prefix. They are kept in sessionHub under the name followed by the
+// prefix, e.g. "team/api", the tunnel without a prefix keeps the name alone.
+
+var (
+	pathMu sync.RWMutex
+	// pathPrefixes holds the prefixes claimed under a tunnel name, longest first
+	pathPrefixes = map[string][]string{}
+)
+
+// normalizePathPrefix cleans the prefix of the path option, "/api/*" and "/api/" are "/api",
+// "/" is no prefix at all
+func normalizePathPrefix(key, value string) (string, error) {
+	prefix := strings.TrimSuffix(value, "*")
+	if !strings.HasPrefix(prefix, "/") || strings.ContainsAny(prefix, "*?#% ") {
+		return "", fmt.Errorf("option %s: %q must be a path like /api", key, value)
+	}
+	prefix = path.Clean(prefix)
+	if prefix == "/" {
+		return "", nil
+	}
+	return prefix, nil
+}
+
+// underPrefix reports whether a request path is served by the tunnel of prefix
+func underPrefix(requestPath, prefix string) bool {
+	return requestPath == prefix || strings.HasPrefix(requestPath, prefix+"/")
+}
+
+func addPathPrefix(name, prefix string) {
+	pathMu.Lock()
+	defer pathMu.Unlock()
+	prefixes := pathPrefixes[name]
+	if slices.Contains(prefixes, prefix) {
+		return
+	}
+	prefixes = append(prefixes, prefix)
+	slices.SortFunc(prefixes, func(a, b string) int {
+		return len(b) - len(a)
+	})
+	pathPrefixes[name] = prefixes
+}
+
+// releasePathPrefix removes a prefix once no session serves it any more
+func releasePathPrefix(name, prefix string) {
+	pathMu.Lock()
+	defer pathMu.Unlock()
+	if _, served := sessionHub.Load(name + prefix); served {
+		// another member of its pool, or a session claiming it since
+		return
+	}
+	prefixes := slices.DeleteFunc(slices.Clone(pathPrefixes[name]), func(p string) bool {
+		return p == prefix
+	})
+	if len(prefixes) == 0 {
+		delete(pathPrefixes, name)
+		return
+	}
+	pathPrefixes[name] = prefixes
+}
+
+// mayShareName reports whether a tunnel opened with key may serve name beside the tunnels
+// already serving it, at another prefix or without one, other than self. A name nobody serves
+// is free, any other needs the key of its tunnels.
+func mayShareName(name, key string, self *forwarder) bool {
+	pathMu.RLock()
+	ids := []string{name}
+	for _, prefix := range pathPrefixes[name] {
+		ids = append(ids, name+prefix)
+	}
+	pathMu.RUnlock()
+	for _, id := range ids {
+		value, found := sessionHub.Load(id)
+		if !found || value == self {
+			continue
+		}
+		// the members of a pool share its key
+		if !sameKey(value.(*forwarder).opts.key, key) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPathRoutes reports whether requests to name may go to different tunnels by path, their
+// connections are then routed request by request
+func hasPathRoutes(name string) bool {
+	pathMu.RLock()
+	defer pathMu.RUnlock()
+	return len(pathPrefixes[name]) > 0
+}
+
+// pathRoute returns the sessionHub key of the tunnel serving a request path under name, the
+// longest prefix wins and the name alone takes the rest
+func pathRoute(name, requestPath string) string {
+	pathMu.RLock()
+	defer pathMu.RUnlock()
+	for _, prefix := range pathPrefixes[name] {
+		if underPrefix(requestPath, prefix) {
+			return name + prefix
+		}
+	}
+	return name
+}
+
+// stripPrefix removes the prefix of a path tunnel from an outgoing request
+func stripPrefix(req *http.Request, prefix string) {
+	req.URL.Path = strings.TrimPrefix(req.URL.Path, prefix)
+	if !strings.HasPrefix(req.URL.Path, "/") {
+		req.URL.Path = "/" + req.URL.Path
+	}
+	if req.URL.RawPath != "" {
+		req.URL.RawPath = strings.TrimPrefix(req.URL.RawPath, prefix)
+		if !strings.HasPrefix(req.URL.RawPath, "/") {
+			req.URL.RawPath = "/" + req.URL.RawPath
+		}
+	}
+}
+
+// serveHTTP serves an HTTP/1.x facade connection request by request through tunnelHandler,
+// a keep-alive connection may carry requests for different tunnels
+func serveHTTP(conn net.Conn, router *hostRouter) {
+	server := &http.Server{
+		Handler:           tunnelHandler(router),
+		ReadHeaderTimeout: limits.readHeaderTimeout,
+		MaxHeaderBytes:    limits.maxHeaderBytes,
+		IdleTimeout:       limits.idleTimeout,
+	}
+	server.Serve(newConnListener(conn))
+}
+
+// connListener accepts a single connection, then waits for it to be closed so the server
+// serving it keeps going
+type connListener struct {
+	conn   net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	return &connListener{conn: conn, closed: make(chan struct{})}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	var conn net.Conn
+	l.once.Do(func() {
+		conn = &closeNotifyConn{Conn: l.conn, closed: l.closed}
+	})
+	if nil != conn {
+		return conn, nil
+	}
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *connListener) Close() error {
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+type closeNotifyConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *closeNotifyConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,177 @@
+package echogy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizePathPrefix(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "/api", want: "/api"},
+		{value: "/api/", want: "/api"},
+		{value: "/api/*", want: "/api"},
+		{value: "/api/v1/../v2", want: "/api/v2"},
+		{value: "/", want: ""},
+		{value: "/*", want: ""},
+		{value: "api", wantErr: true},
+		{value: "/a?b", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := normalizePathPrefix("path", tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("normalizePathPrefix(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestPathRoute(t *testing.T) {
+	for _, prefix := range []string{"/api", "/api/admin"} {
+		sessionHub.Store("team"+prefix, &forwarder{})
+		addPathPrefix("team", prefix)
+	}
+	defer func() {
+		for _, prefix := range []string{"/api", "/api/admin"} {
+			sessionHub.Delete("team" + prefix)
+			releasePathPrefix("team", prefix)
+		}
+		if hasPathRoutes("team") {
+			t.Error("prefixes left after their tunnels were gone")
+		}
+	}()
+
+	tests := map[string]string{
+		"/":                "team",
+		"/apis":            "team",
+		"/api":             "team/api",
+		"/api/users":       "team/api",
+		"/api/admin/users": "team/api/admin",
+	}
+	for path, want := range tests {
+		if got := pathRoute("team", path); got != want {
+			t.Errorf("pathRoute(%q) = %q, want %q", path, got, want)
+		}
+	}
+	if got := pathRoute("other", "/api"); got != "other" {
+		t.Errorf("pathRoute() = %q for a name without prefixes", got)
+	}
+
+	// a prefix stays while a session serves it
+	releasePathPrefix("team", "/api")
+	if got := pathRoute("team", "/api/users"); got != "team/api" {
+		t.Errorf("a served prefix was released")
+	}
+}
+
+func TestMayShareName(t *testing.T) {
+	if !mayShareName("team", "", nil) {
+		t.Error("a name nobody serves isn't free")
+	}
+	owner := &forwarder{opts: &tunnelOptions{key: "team-secret"}}
+	sessionHub.Store("team", owner)
+	defer sessionHub.Delete("team")
+	api := &forwarder{opts: &tunnelOptions{key: "team-secret", path: "/api"}}
+	sessionHub.Store("team/api", api)
+	addPathPrefix("team", "/api")
+	defer func() {
+		sessionHub.Delete("team/api")
+		releasePathPrefix("team", "/api")
+	}()
+
+	tests := []struct {
+		key  string
+		self *forwarder
+		want bool
+	}{
+		{key: "team-secret", want: true},
+		{key: "guessed-it", want: false},
+		{key: "", want: false},
+		// the tunnel itself doesn't count
+		{key: "guessed-it", self: owner, want: false},
+	}
+	for _, tt := range tests {
+		if got := mayShareName("team", tt.key, tt.self); got != tt.want {
+			t.Errorf("mayShareName(team, %q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+	sessionHub.Delete("team/api")
+	if !mayShareName("team", "", owner) {
+		t.Error("the only tunnel of the name can't keep it")
+	}
+}
+
+// backendTunnel is a forwarder whose channels are connections to a test server
+func backendTunnel(id, path string, strip bool, handler http.HandlerFunc) (*forwarder, func()) {
+	backend := httptest.NewServer(handler)
+	fwd := &forwarder{accessId: id + path, name: id, path: path, domains: []string{"webs.sh"},
+		opts: &tunnelOptions{path: path, strip: strip}}
+	fwd.transportOnce.Do(func() {
+		fwd.transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("tcp", backend.Listener.Addr().String())
+			},
+		}
+	})
+	sessionHub.Store(fwd.accessId, fwd)
+	if path != "" {
+		addPathPrefix(id, path)
+	}
+	return fwd, func() {
+		sessionHub.Delete(fwd.accessId)
+		releasePathPrefix(id, path)
+		backend.Close()
+	}
+}
+
+func TestFacadePathRouting(t *testing.T) {
+	_, closeFrontend := backendTunnel("team", "", false, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "frontend "+r.URL.Path)
+	})
+	defer closeFrontend()
+	_, closeAPI := backendTunnel("team", "/api", true, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "api "+r.URL.Path)
+	})
+	defer closeAPI()
+
+	// a single keep-alive connection to the facade
+	dials := 0
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			dials++
+			c, s := net.Pipe()
+			go handleConnection(s, newHostRouter(1, "webs.sh"), func(string, string, *hijackConn) bool { return false })
+			return c, nil
+		},
+	}}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "http://team.webs.sh/", want: "frontend /"},
+		{url: "http://team.webs.sh/api/users", want: "api /users"},
+		{url: "http://team.webs.sh/app.js", want: "frontend /app.js"},
+		{url: "http://team.webs.sh/api", want: "api /"},
+		{url: "http://team.webs.sh/apidocs/ok", want: "frontend /apidocs/ok"},
+	}
+	for _, tt := range tests {
+		resp, err := client.Get(tt.url)
+		if err != nil {
+			t.Fatalf("GET %s: %v", tt.url, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != tt.want {
+			t.Errorf("GET %s = %q, want %q", tt.url, body, tt.want)
+		}
+	}
+	if dials != 1 {
+		t.Errorf("%d connections to the facade, the requests should share one", dials)
+	}
+}
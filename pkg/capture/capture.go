@@ -0,0 +1,64 @@
+package capture
+
+import (
+	"sync"
+)
+
+// DefaultLimit is the number of bytes kept per message body
+const DefaultLimit = 64 << 10
+
+// Buffer is a thread-safe writer that keeps the first limit bytes written to it
+// and counts the rest, it is used to capture message bodies without growing unbounded
+type Buffer struct {
+	mu    sync.RWMutex
+	limit int
+	data  []byte
+	total int64
+}
+
+// New creates a Buffer that keeps at most limit bytes
+func New(limit int) *Buffer {
+	if limit < 0 {
+		limit = 0
+	}
+	return &Buffer{limit: limit}
+}
+
+// Write implements io.Writer, it never fails so it can sit in an io.MultiWriter or TeeReader
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total += int64(len(p))
+	if room := b.limit - len(b.data); room > 0 {
+		b.data = append(b.data, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+// Bytes returns a copy of the captured bytes
+func (b *Buffer) Bytes() []byte {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]byte(nil), b.data...)
+}
+
+// Len returns the number of captured bytes
+func (b *Buffer) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.data)
+}
+
+// Total returns the number of bytes written, including the ones beyond the limit
+func (b *Buffer) Total() int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.total
+}
+
+// Truncated reports whether more bytes were written than kept
+func (b *Buffer) Truncated() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.total > int64(len(b.data))
+}
@@ -0,0 +1,73 @@
+package capture
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBuffer(t *testing.T) {
+	tests := []struct {
+		name          string
+		limit         int
+		writes        []string
+		wantBytes     string
+		wantTotal     int64
+		wantTruncated bool
+	}{
+		{
+			name:      "below limit",
+			limit:     10,
+			writes:    []string{"hello", " ", "you"},
+			wantBytes: "hello you",
+			wantTotal: 9,
+		},
+		{
+			name:          "single write over limit",
+			limit:         4,
+			writes:        []string{"hello"},
+			wantBytes:     "hell",
+			wantTotal:     5,
+			wantTruncated: true,
+		},
+		{
+			name:          "writes after limit are counted",
+			limit:         5,
+			writes:        []string{"hello", " world", "!"},
+			wantBytes:     "hello",
+			wantTotal:     12,
+			wantTruncated: true,
+		},
+		{
+			name:          "zero limit",
+			limit:         0,
+			writes:        []string{"abc"},
+			wantBytes:     "",
+			wantTotal:     3,
+			wantTruncated: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New(tt.limit)
+			for _, w := range tt.writes {
+				n, err := b.Write([]byte(w))
+				if err != nil || n != len(w) {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if got := b.Bytes(); !bytes.Equal(got, []byte(tt.wantBytes)) {
+				t.Errorf("Bytes() = %q, want %q", got, tt.wantBytes)
+			}
+			if b.Len() != len(tt.wantBytes) {
+				t.Errorf("Len() = %d, want %d", b.Len(), len(tt.wantBytes))
+			}
+			if b.Total() != tt.wantTotal {
+				t.Errorf("Total() = %d, want %d", b.Total(), tt.wantTotal)
+			}
+			if b.Truncated() != tt.wantTruncated {
+				t.Errorf("Truncated() = %v, want %v", b.Truncated(), tt.wantTruncated)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+// Package errorpage renders error responses as HTML, JSON or plain text, picked by the
+// Accept header, from built-in templates or ones provided by the operator
+package errorpage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Statuses are the pages every renderer has a message for
+var Statuses = []int{
+	http.StatusBadRequest,
+	http.StatusUnauthorized,
+	http.StatusForbidden,
+	http.StatusNotFound,
+	http.StatusMisdirectedRequest,
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+var messages = map[int]string{
+	http.StatusBadRequest:                  "The request could not be understood.",
+	http.StatusUnauthorized:                "This tunnel requires authentication.",
+	http.StatusForbidden:                   "You are not allowed to access this tunnel.",
+	http.StatusNotFound:                    "There is no tunnel at this address.",
+	http.StatusMisdirectedRequest:          "This server does not serve this host.",
+	http.StatusTooManyRequests:             "Too many requests, slow down and try again.",
+	http.StatusBadGateway:                  "The tunnel could not reach its local service.",
+	http.StatusServiceUnavailable:          "The tunnel is not available right now.",
+	http.StatusGatewayTimeout:              "The local service behind the tunnel did not answer in time.",
+	http.StatusRequestTimeout:              "The request took too long to arrive.",
+	http.StatusRequestURITooLong:           "The request line is too long.",
+	http.StatusRequestHeaderFieldsTooLarge: "The request headers are too large.",
+}
+
+// Page is what a template renders
+type Page struct {
+	Status    int
+	Title     string // status text
+	Message   string
+	RequestID string // quoted when asking for support, it is also sent as X-Request-Id
+	Time      time.Time
+}
+
+// NewPage creates a page with a fresh request ID, an empty message takes the default one
+func NewPage(status int, message string) *Page {
+	if message == "" {
+		message = messages[status]
+	}
+	return &Page{
+		Status:    status,
+		Title:     http.StatusText(status),
+		Message:   message,
+		RequestID: newRequestID(),
+		Time:      time.Now(),
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+type format struct {
+	ext         string
+	contentType string
+}
+
+var (
+	htmlFormat = format{ext: "html", contentType: "text/html; charset=utf-8"}
+	jsonFormat = format{ext: "json", contentType: "application/json"}
+	textFormat = format{ext: "txt", contentType: "text/plain; charset=utf-8"}
+	formats    = []format{htmlFormat, jsonFormat, textFormat}
+)
+
+type executor interface {
+	Execute(w io.Writer, data any) error
+}
+
+//go:embed templates
+var builtin embed.FS
+
+// templateName matches the files of a template directory, a status code or error for any status
+var templateName = regexp.MustCompile(`^(\d{3}|error)\.(html|json|txt)$`)
+
+// Renderer renders pages from templates named after the status, like 404.html,
+// or error.html for any status without its own
+type Renderer struct {
+	templates map[string]executor
+}
+
+var funcs = map[string]any{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parse(name string, text string) (executor, error) {
+	if strings.HasSuffix(name, ".html") {
+		return htmltemplate.New(name).Funcs(funcs).Parse(text)
+	}
+	return texttemplate.New(name).Funcs(funcs).Parse(text)
+}
+
+// Default returns a renderer with the built-in templates only
+func Default() *Renderer {
+	r := &Renderer{templates: map[string]executor{}}
+	for _, f := range formats {
+		name := "error." + f.ext
+		text, err := builtin.ReadFile("templates/" + name)
+		if err != nil {
+			panic(err)
+		}
+		t, err := parse(name, string(text))
+		if err != nil {
+			panic(err)
+		}
+		r.templates[name] = t
+	}
+	return r
+}
+
+// Load returns a renderer using the templates found in dir, the built-in ones fill the gaps
+func Load(dir string) (*Renderer, error) {
+	r := Default()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !templateName.MatchString(entry.Name()) {
+			continue
+		}
+		text, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		t, err := parse(entry.Name(), string(text))
+		if err != nil {
+			return nil, err
+		}
+		r.templates[entry.Name()] = t
+	}
+	return r, nil
+}
+
+// negotiate picks a format for an Accept header, named types win over wildcards and plain
+// text is the answer when nothing is named, like for curl
+func negotiate(accept string) format {
+	best, bestQ := textFormat, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		for _, f := range formats {
+			if name, _, _ := strings.Cut(f.contentType, ";"); name == mediaType && q > bestQ {
+				best, bestQ = f, q
+			}
+		}
+	}
+	return best
+}
+
+// Render renders the page in the format the Accept header asks for
+func (r *Renderer) Render(p *Page, accept string) (contentType string, body []byte) {
+	f := negotiate(accept)
+	t, ok := r.templates[strconv.Itoa(p.Status)+"."+f.ext]
+	if !ok {
+		t = r.templates["error."+f.ext]
+	}
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, p); err != nil {
+		// a broken operator template still gets a page out
+		b.Reset()
+		fmt.Fprintf(b, "%d %s\n\nRequest ID: %s\n", p.Status, p.Title, p.RequestID)
+		f = textFormat
+	}
+	return f.contentType, b.Bytes()
+}
+
+func accept(req *http.Request) string {
+	if nil == req {
+		return ""
+	}
+	return req.Header.Get("Accept")
+}
+
+// Write sends the page as the response of a handler
+func (r *Renderer) Write(w http.ResponseWriter, req *http.Request, p *Page) {
+	r.WriteResponse(w, r.Response(req, p))
+}
+
+// WriteResponse writes a page already rendered by Response, its body stays readable afterwards
+func (r *Renderer) WriteResponse(w http.ResponseWriter, resp *http.Response) {
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
+
+// Response returns the page as a response closing the connection, for a request that may be nil
+func (r *Renderer) Response(req *http.Request, p *Page) *http.Response {
+	contentType, body := r.Render(p, accept(req))
+	resp := &http.Response{
+		StatusCode:    p.Status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		Close:         true,
+		Request:       req,
+	}
+	resp.Header.Set("Content-Type", contentType)
+	resp.Header.Set("X-Request-Id", p.RequestID)
+	resp.Header.Set("Cache-Control", "no-store")
+	resp.Header.Set("Server", "echogy")
+	return resp
+}
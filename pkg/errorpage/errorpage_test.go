@@ -0,0 +1,124 @@
+package errorpage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := map[string]format{
+		"":    textFormat,
+		"*/*": textFormat,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": htmlFormat,
+		"application/json":                  jsonFormat,
+		"text/html;q=0.5, application/json": jsonFormat,
+		"text/plain, text/html;q=0.1":       textFormat,
+		"image/png":                         textFormat,
+		"text":                              textFormat,
+	}
+	for accept, want := range tests {
+		if got := negotiate(accept); got != want {
+			t.Errorf("negotiate(%q) = %s, want %s", accept, got.ext, want.ext)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	r := Default()
+	for _, status := range Statuses {
+		p := NewPage(status, "")
+		if p.Message == "" || p.Title == "" || len(p.RequestID) != 16 {
+			t.Fatalf("page %d = %+v", status, p)
+		}
+		for _, accept := range []string{"text/html", "application/json", ""} {
+			contentType, body := r.Render(p, accept)
+			if !bytes.Contains(body, []byte(p.RequestID)) {
+				t.Errorf("%d as %s has no request id: %s", status, contentType, body)
+			}
+		}
+		_, body := r.Render(p, "application/json")
+		var decoded struct {
+			Status    int    `json:"status"`
+			RequestID string `json:"requestId"`
+		}
+		if err := json.Unmarshal(body, &decoded); err != nil || decoded.Status != status {
+			t.Errorf("%d as json = %s, %v", status, body, err)
+		}
+	}
+
+	// html is escaped, whatever the message says
+	_, body := r.Render(NewPage(http.StatusNotFound, "<script>"), "text/html")
+	if bytes.Contains(body, []byte("<script>")) {
+		t.Errorf("message not escaped: %s", body)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "404.html"), []byte("<p>lost {{.RequestID}}</p>"), 0o644)
+	os.WriteFile(filepath.Join(dir, "error.txt"), []byte("oops {{.Status}}"), 0o644)
+	os.WriteFile(filepath.Join(dir, "notes.md"), []byte("{{"), 0o644)
+	r, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPage(http.StatusNotFound, "")
+	if _, body := r.Render(p, "text/html"); string(body) != "<p>lost "+p.RequestID+"</p>" {
+		t.Errorf("404.html = %s", body)
+	}
+	if _, body := r.Render(NewPage(http.StatusBadGateway, ""), "text/html"); !bytes.Contains(body, []byte("<!DOCTYPE html>")) {
+		t.Errorf("502 didn't fall back to the built-in page: %s", body)
+	}
+	if _, body := r.Render(NewPage(http.StatusBadGateway, ""), "text/plain"); string(body) != "oops 502" {
+		t.Errorf("error.txt = %s", body)
+	}
+
+	os.WriteFile(filepath.Join(dir, "502.json"), []byte("{{"), 0o644)
+	if _, err := Load(dir); err == nil {
+		t.Error("a broken template loaded")
+	}
+}
+
+func TestWrite(t *testing.T) {
+	r := Default()
+	p := NewPage(http.StatusTooManyRequests, "")
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	r.Write(rec, req, p)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("X-Request-Id") != p.RequestID ||
+		rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Write = %d %v", rec.Code, rec.Header())
+	}
+
+	b := &bytes.Buffer{}
+	r.Response(nil, p).Write(b)
+	resp, err := http.ReadResponse(bufio.NewReader(b), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || !resp.Close ||
+		!strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("Response = %d close %v %v", resp.StatusCode, resp.Close, resp.Header)
+	}
+
+	rec = httptest.NewRecorder()
+	resp = r.Response(req, p)
+	r.WriteResponse(rec, resp)
+	body, _ := io.ReadAll(resp.Body)
+	if rec.Code != http.StatusTooManyRequests || rec.Body.String() != string(body) ||
+		rec.Header().Get("Content-Length") != strconv.Itoa(len(body)) {
+		t.Errorf("WriteResponse = %d %v, body left %q", rec.Code, rec.Header(), body)
+	}
+}
@@ -0,0 +1,315 @@
+// Package httpstream follows the HTTP/1.x messages of a connection from the bytes
+// flowing in each direction, however they are split across reads and writes
+package httpstream
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/youkale/echogy/pkg/capture"
+)
+
+// feedDepth is how many pieces of a direction may wait for the parser, past it the
+// connection is no longer followed rather than slowing it down
+const feedDepth = 256
+
+// Exchange is a request paired with its response
+type Exchange struct {
+	Request      *http.Request
+	Response     *http.Response
+	RequestBody  *capture.Buffer // nil when capturing is disabled
+	ResponseBody *capture.Buffer // nil when capturing is disabled
+	Start        time.Time       // first byte of the request
+	End          time.Time       // last byte of the response
+}
+
+// Duration is the time from the first request byte to the last response byte
+func (e *Exchange) Duration() time.Duration {
+	return e.End.Sub(e.Start)
+}
+
+type piece struct {
+	data []byte
+	at   time.Time
+}
+
+// feed turns the pieces written to one direction into a reader for the parser,
+// remembering when the last piece it handed out was written
+type feed struct {
+	ch      chan piece
+	current piece
+	at      time.Time // when the bytes last read were written
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newFeed() *feed {
+	return &feed{ch: make(chan piece, feedDepth)}
+}
+
+// write queues a copy of b without ever blocking, it reports false once the parser fell behind
+func (f *feed) write(b []byte, at time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return false
+	}
+	select {
+	case f.ch <- piece{data: append([]byte(nil), b...), at: at}:
+		return true
+	default:
+		f.closed = true
+		close(f.ch)
+		return false
+	}
+}
+
+func (f *feed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.ch)
+	}
+}
+
+func (f *feed) Read(b []byte) (int, error) {
+	if len(f.current.data) == 0 {
+		p, ok := <-f.ch
+		if !ok {
+			return 0, io.EOF
+		}
+		f.current = p
+	}
+	n := copy(b, f.current.data)
+	f.current.data = f.current.data[n:]
+	f.at = f.current.at
+	return n, nil
+}
+
+// pending is a request waiting for its response
+type pending struct {
+	req     *http.Request
+	body    *capture.Buffer
+	start   time.Time
+	upgrade bool          // the connection may switch protocols after this request
+	read    chan struct{} // closed once the request body was read
+}
+
+// decision tells the request side whether an upgrade was accepted
+type decision struct {
+	switched bool
+	client   func(io.Reader)
+}
+
+// Upgraded receives what a direction carries after the connection switched protocols,
+// it reads until it is done or r ends, anything left is discarded
+type Upgraded func(r io.Reader)
+
+// Tracker pairs the requests written by a client with the responses of a server.
+// Parsing runs on its own goroutines so feeding it never blocks the connection, and a
+// direction that isn't HTTP, or that the parser can't keep up with, is simply no longer followed.
+type Tracker struct {
+	requests  *feed
+	responses *feed
+	queue     chan *pending
+	stopped   chan struct{} // closed when responses are no longer followed, nothing takes from queue
+	switched  chan decision
+	limit     int // body capture limit, negative disables capturing
+	done      func(*Exchange)
+
+	// OnUpgrade is called after done for an exchange that switched protocols, like a
+	// WebSocket handshake or a CONNECT, it returns what reads each direction from then on.
+	// A nil Upgraded discards the direction. It must be set before anything is fed.
+	OnUpgrade func(e *Exchange) (client, server Upgraded)
+}
+
+// NewTracker starts following a connection, done is called once for every complete exchange.
+// Bodies are captured up to limit bytes, a negative limit disables capturing.
+func NewTracker(limit int, done func(*Exchange)) *Tracker {
+	t := &Tracker{
+		requests:  newFeed(),
+		responses: newFeed(),
+		queue:     make(chan *pending, feedDepth),
+		stopped:   make(chan struct{}),
+		switched:  make(chan decision, 1),
+		limit:     limit,
+		done:      done,
+	}
+	go t.readRequests()
+	go t.readResponses()
+	return t
+}
+
+// Request feeds bytes the client sent
+func (t *Tracker) Request(b []byte) {
+	t.requests.write(b, time.Now())
+}
+
+// Response feeds bytes the server sent
+func (t *Tracker) Response(b []byte) {
+	t.responses.write(b, time.Now())
+}
+
+// Close ends both directions, a response delimited by the connection closing completes here
+func (t *Tracker) Close() {
+	t.requests.close()
+	t.responses.close()
+}
+
+func (t *Tracker) newBody() *capture.Buffer {
+	if t.limit < 0 {
+		return nil
+	}
+	return capture.New(t.limit)
+}
+
+// orDiscard returns w, or io.Discard when w is nil
+func orDiscard(w *capture.Buffer) io.Writer {
+	if nil == w {
+		return io.Discard
+	}
+	return w
+}
+
+func (t *Tracker) readRequests() {
+	defer close(t.queue)
+	// whatever isn't understood is drained so the feed never fills up
+	defer io.Copy(io.Discard, t.requests)
+
+	br := bufio.NewReader(t.requests)
+	for {
+		if _, err := br.Peek(1); err != nil {
+			return
+		}
+		start := t.requests.at
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		// the body reader already knows the transfer coding, what gets captured is the entity
+		req.TransferEncoding = nil
+		p := &pending{req: req, body: t.newBody(), start: start, upgrade: mayUpgrade(req),
+			read: make(chan struct{})}
+		select {
+		case t.queue <- p:
+		case <-t.stopped:
+			return
+		}
+		_, err = io.Copy(orDiscard(p.body), req.Body)
+		close(p.read)
+		if err != nil {
+			return
+		}
+		if !p.upgrade {
+			continue
+		}
+		// nothing but the response tells whether what follows is still HTTP
+		d, ok := <-t.switched
+		if !ok {
+			return
+		}
+		if d.switched {
+			if nil != d.client {
+				d.client(br)
+			}
+			return
+		}
+	}
+}
+
+func (t *Tracker) readResponses() {
+	defer io.Copy(io.Discard, t.responses)
+	defer close(t.switched)
+	// before draining, the requests stop waiting for responses that won't be read
+	defer close(t.stopped)
+
+	br := bufio.NewReader(t.responses)
+	for {
+		if _, err := br.Peek(1); err != nil {
+			return
+		}
+		p, ok := <-t.queue
+		if !ok {
+			// a response nobody asked for, or the requests stopped being HTTP
+			return
+		}
+		resp, err := readFinalResponse(br, p.req)
+		if err != nil {
+			return
+		}
+		body := t.newBody()
+		// a body cut short by the connection still completes the exchange, as far as it got
+		_, err = io.Copy(orDiscard(body), resp.Body)
+		resp.TransferEncoding = nil
+		end := t.responses.at
+		// the exchange is only complete with the request body, which is read on its own goroutine
+		<-p.read
+		e := &Exchange{
+			Request:      p.req,
+			Response:     resp,
+			RequestBody:  p.body,
+			ResponseBody: body,
+			Start:        p.start,
+			End:          end,
+		}
+		t.done(e)
+		if err != nil {
+			return
+		}
+
+		switched := e.Switched()
+		var client, server Upgraded
+		if switched && nil != t.OnUpgrade {
+			client, server = t.OnUpgrade(e)
+		}
+		if p.upgrade {
+			t.switched <- decision{switched: switched, client: client}
+		}
+		if switched {
+			if nil != server {
+				server(br)
+			}
+			return
+		}
+	}
+}
+
+// mayUpgrade reports whether the connection may stop carrying HTTP/1.x after req
+func mayUpgrade(req *http.Request) bool {
+	if req.Method == http.MethodConnect {
+		return true
+	}
+	for _, v := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readFinalResponse skips interim responses like 100 Continue, a 101 is final
+func readFinalResponse(br *bufio.Reader, req *http.Request) (*http.Response, error) {
+	for {
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+			return resp, nil
+		}
+	}
+}
+
+// Switched reports whether the connection stopped carrying HTTP/1.x after the exchange
+func (e *Exchange) Switched() bool {
+	return e.Response.StatusCode == http.StatusSwitchingProtocols ||
+		(e.Request.Method == http.MethodConnect && e.Response.StatusCode/100 == 2)
+}
@@ -0,0 +1,272 @@
+package httpstream
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type step struct {
+	request bool // written by the client, otherwise by the server
+	data    string
+}
+
+func req(data string) step  { return step{request: true, data: data} }
+func resp(data string) step { return step{data: data} }
+
+// split cuts every step in pieces of n bytes, the way reads and writes may
+func split(steps []step, n int) []step {
+	var pieces []step
+	for _, s := range steps {
+		for len(s.data) > n {
+			pieces = append(pieces, step{request: s.request, data: s.data[:n]})
+			s.data = s.data[n:]
+		}
+		pieces = append(pieces, s)
+	}
+	return pieces
+}
+
+type result struct {
+	method, uri       string
+	status            int
+	reqBody, respBody string
+}
+
+func run(t *testing.T, steps []step) ([]result, []*Exchange) {
+	t.Helper()
+	var mu sync.Mutex
+	var exchanges []*Exchange
+	tracker := NewTracker(1024, func(e *Exchange) {
+		mu.Lock()
+		defer mu.Unlock()
+		exchanges = append(exchanges, e)
+	})
+	// the steps are written a millisecond apart
+	start := time.Now()
+	for i, s := range steps {
+		f := tracker.responses
+		if s.request {
+			f = tracker.requests
+		}
+		if !f.write([]byte(s.data), start.Add(time.Duration(i)*time.Millisecond)) {
+			t.Fatalf("step %d overflowed the feed", i)
+		}
+	}
+	tracker.Close()
+	// every exchange is done once the responses are no longer followed
+	waitFor(t, "the end of the responses", tracker.stopped)
+
+	mu.Lock()
+	defer mu.Unlock()
+	results := make([]result, len(exchanges))
+	for i, e := range exchanges {
+		results[i] = result{
+			method:   e.Request.Method,
+			uri:      e.Request.RequestURI,
+			status:   e.Response.StatusCode,
+			reqBody:  string(e.RequestBody.Bytes()),
+			respBody: string(e.ResponseBody.Bytes()),
+		}
+	}
+	return results, exchanges
+}
+
+func waitFor(t *testing.T, what string, event <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-event:
+	case <-time.After(time.Second):
+		t.Fatalf("%s didn't come", what)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	chunked := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"
+	tests := []struct {
+		name  string
+		steps []step
+		want  []result
+	}{
+		{
+			name: "keep-alive with chunked response",
+			steps: []step{
+				req("GET /a HTTP/1.1\r\nHost: x\r\n\r\n"),
+				resp(chunked),
+				req("POST /b HTTP/1.1\r\nHost: x\r\nContent-Length: 3\r\n\r\nabc"),
+				resp("HTTP/1.1 404 Not Found\r\nContent-Length: 4\r\n\r\nnope"),
+			},
+			want: []result{
+				{method: "GET", uri: "/a", status: 200, respBody: "hello world"},
+				{method: "POST", uri: "/b", status: 404, reqBody: "abc", respBody: "nope"},
+			},
+		},
+		{
+			name: "pipelined",
+			steps: []step{
+				req("GET /1 HTTP/1.1\r\nHost: x\r\n\r\nHEAD /2 HTTP/1.1\r\nHost: x\r\n\r\nGET /3 HTTP/1.1\r\nHost: x\r\n\r\n"),
+				resp("HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\n1HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nHTTP/1.1 500 Internal Server Error\r\nContent-Length: 1\r\n\r\n3"),
+			},
+			want: []result{
+				{method: "GET", uri: "/1", status: 200, respBody: "1"},
+				{method: "HEAD", uri: "/2", status: 200},
+				{method: "GET", uri: "/3", status: 500, respBody: "3"},
+			},
+		},
+		{
+			name: "expect continue",
+			steps: []step{
+				req("PUT /up HTTP/1.1\r\nHost: x\r\nExpect: 100-continue\r\nTransfer-Encoding: chunked\r\n\r\n"),
+				resp("HTTP/1.1 100 Continue\r\n\r\n"),
+				req("2\r\nok\r\n0\r\n\r\n"),
+				resp("HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n"),
+			},
+			want: []result{{method: "PUT", uri: "/up", status: 201, reqBody: "ok"}},
+		},
+		{
+			name: "close delimited",
+			steps: []step{
+				req("GET /stream HTTP/1.0\r\nHost: x\r\n\r\n"),
+				resp("HTTP/1.0 200 OK\r\n\r\nuntil "),
+				resp("the end"),
+			},
+			want: []result{{method: "GET", uri: "/stream", status: 200, respBody: "until the end"}},
+		},
+		{
+			name: "upgrade stops parsing",
+			steps: []step{
+				req("GET /ws HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"),
+				resp("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n"),
+				req("\x81\x85GET / HTTP/1.1\r\n"),
+				resp("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"),
+			},
+			want: []result{{method: "GET", uri: "/ws", status: 101}},
+		},
+		{
+			name: "not http",
+			steps: []step{
+				req("\x16\x03\x01\x02\x00"),
+				resp("HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		for _, size := range []int{1, 7, 1 << 20} {
+			t.Run(tt.name, func(t *testing.T) {
+				got, _ := run(t, split(tt.steps, size))
+				if len(got) != len(tt.want) {
+					t.Fatalf("pieces of %d: got %d exchanges %+v, want %d", size, len(got), got, len(tt.want))
+				}
+				for i := range got {
+					if got[i] != tt.want[i] {
+						t.Errorf("pieces of %d: exchange %d = %+v, want %+v", size, i, got[i], tt.want[i])
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestTrackerTiming(t *testing.T) {
+	_, exchanges := run(t, []step{
+		req("GET / HTTP/1.1\r\n"),
+		req("Host: x\r\n\r\n"),
+		resp("HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nab"),
+		resp("cd"),
+	})
+	if len(exchanges) != 1 {
+		t.Fatalf("got %d exchanges", len(exchanges))
+	}
+	// the request is two pieces and the response two, a millisecond apart
+	if d := exchanges[0].Duration(); d != 3*time.Millisecond {
+		t.Errorf("duration %s doesn't run from the first request byte to the last response byte", d)
+	}
+}
+
+func TestTrackerUpgrade(t *testing.T) {
+	var mu sync.Mutex
+	var statuses []int
+	got := map[string]string{}
+	tracker := NewTracker(-1, func(e *Exchange) {
+		mu.Lock()
+		defer mu.Unlock()
+		statuses = append(statuses, e.Response.StatusCode)
+	})
+	ended := map[string]chan struct{}{"client": make(chan struct{}), "server": make(chan struct{})}
+	read := func(side string) Upgraded {
+		return func(r io.Reader) {
+			defer close(ended[side])
+			data, _ := io.ReadAll(r)
+			mu.Lock()
+			defer mu.Unlock()
+			got[side] = string(data)
+		}
+	}
+	tracker.OnUpgrade = func(e *Exchange) (Upgraded, Upgraded) {
+		return read("client"), read("server")
+	}
+
+	for _, s := range []step{
+		// refused upgrades keep the connection on HTTP
+		req("GET /h2 HTTP/1.1\r\nHost: x\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\n\r\n"),
+		resp("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n"),
+		req("CONNECT x:443 HTTP/1.1\r\nHost: x:443\r\n\r\n"),
+		resp("HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 0\r\n\r\n"),
+		req("GET /ws HTTP/1.1\r\nHost: x\r\nConnection: keep-alive, Upgrade\r\nUpgrade: websocket\r\n\r\nfrom"),
+		resp("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\nfrom "),
+		req(" client"),
+		resp("server"),
+	} {
+		if s.request {
+			tracker.Request([]byte(s.data))
+		} else {
+			tracker.Response([]byte(s.data))
+		}
+	}
+	tracker.Close()
+	waitFor(t, "the end of the responses", tracker.stopped)
+	waitFor(t, "the end of the upgraded client side", ended["client"])
+	waitFor(t, "the end of the upgraded server side", ended["server"])
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(statuses) != 3 || statuses[2] != http.StatusSwitchingProtocols {
+		t.Errorf("statuses = %v", statuses)
+	}
+	if got["client"] != "from client" || got["server"] != "from server" {
+		t.Errorf("after the upgrade got %q", got)
+	}
+}
+
+func TestTrackerStopsQueueing(t *testing.T) {
+	tracker := NewTracker(-1, func(*Exchange) {})
+	defer tracker.Close()
+	// the first response is malformed, the responses are no longer followed
+	tracker.Request([]byte("GET / HTTP/1.1\r\nHost: a\r\n\r\n"))
+	tracker.Response([]byte("not a response\r\n\r\n"))
+	waitFor(t, "the end of the responses", tracker.stopped)
+
+	// the pipelined requests past it aren't queued for a response nobody reads, once the
+	// queue is full the requests stop being followed
+	tracker.Request([]byte(strings.Repeat("GET / HTTP/1.1\r\nHost: a\r\n\r\n", 2*feedDepth)))
+	tracker.Close()
+	queued := 0
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-tracker.queue:
+			if !ok {
+				if queued > feedDepth {
+					t.Errorf("%d requests were queued for responses nobody reads", queued)
+				}
+				return
+			}
+			queued++
+		case <-timeout:
+			t.Fatal("the requests kept waiting to be queued")
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Package wsframe reads WebSocket frames (RFC 6455) off a connection for inspection,
+// keeping only the start of each payload
+package wsframe
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Opcode tells what a frame carries
+type Opcode byte
+
+const (
+	Continuation Opcode = 0x0
+	Text         Opcode = 0x1
+	Binary       Opcode = 0x2
+	Close        Opcode = 0x8
+	Ping         Opcode = 0x9
+	Pong         Opcode = 0xA
+)
+
+func (o Opcode) String() string {
+	switch o {
+	case Continuation:
+		return "cont"
+	case Text:
+		return "text"
+	case Binary:
+		return "binary"
+	case Close:
+		return "close"
+	case Ping:
+		return "ping"
+	case Pong:
+		return "pong"
+	}
+	return fmt.Sprintf("0x%x", byte(o))
+}
+
+// IsControl reports whether the opcode is a control frame, which may come between the frames of a message
+func (o Opcode) IsControl() bool {
+	return o&0x8 != 0
+}
+
+// Frame is a frame header and the start of its payload
+type Frame struct {
+	Fin     bool
+	Opcode  Opcode
+	Masked  bool
+	Length  int64  // payload length
+	Size    int64  // bytes on the wire, header included
+	Payload []byte // first bytes of the payload, unmasked
+}
+
+// EndsMessage reports whether the frame is the last of a text or binary message
+func (f *Frame) EndsMessage() bool {
+	return f.Fin && !f.Opcode.IsControl()
+}
+
+var errLength = errors.New("wsframe: invalid payload length")
+
+// ReadFrame reads the next frame from r, keeping at most preview bytes of its payload
+func ReadFrame(r io.Reader, preview int) (*Frame, error) {
+	var head [14]byte
+	if _, err := io.ReadFull(r, head[:2]); err != nil {
+		return nil, err
+	}
+	f := &Frame{
+		Fin:    head[0]&0x80 != 0,
+		Opcode: Opcode(head[0] & 0x0F),
+		Masked: head[1]&0x80 != 0,
+		Length: int64(head[1] & 0x7F),
+	}
+
+	extra := 0
+	switch f.Length {
+	case 126:
+		extra = 2
+	case 127:
+		extra = 8
+	}
+	if f.Masked {
+		extra += 4
+	}
+	if _, err := io.ReadFull(r, head[2:2+extra]); err != nil {
+		return nil, err
+	}
+	ext := head[2 : 2+extra]
+	switch f.Length {
+	case 126:
+		f.Length, ext = int64(binary.BigEndian.Uint16(ext)), ext[2:]
+	case 127:
+		length := binary.BigEndian.Uint64(ext)
+		if length > 1<<63-1 {
+			return nil, errLength
+		}
+		f.Length, ext = int64(length), ext[8:]
+	}
+	f.Size = int64(2+extra) + f.Length
+
+	keep := min(f.Length, int64(max(preview, 0)))
+	f.Payload = make([]byte, keep)
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return nil, err
+	}
+	if f.Masked {
+		for i := range f.Payload {
+			f.Payload[i] ^= ext[i%4]
+		}
+	}
+	if _, err := io.CopyN(io.Discard, r, f.Length-keep); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,85 @@
+package wsframe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// frame encodes a frame the way a client (masked) or server would send it
+func frame(fin bool, op Opcode, payload []byte, mask []byte) []byte {
+	b := &bytes.Buffer{}
+	first := byte(op)
+	if fin {
+		first |= 0x80
+	}
+	b.WriteByte(first)
+	maskBit := byte(0)
+	if mask != nil {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		b.WriteByte(maskBit | byte(n))
+	case n <= 0xFFFF:
+		b.WriteByte(maskBit | 126)
+		binary.Write(b, binary.BigEndian, uint16(n))
+	default:
+		b.WriteByte(maskBit | 127)
+		binary.Write(b, binary.BigEndian, uint64(n))
+	}
+	if mask != nil {
+		b.Write(mask)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+	b.Write(payload)
+	return b.Bytes()
+}
+
+func TestReadFrame(t *testing.T) {
+	large := bytes.Repeat([]byte("x"), 70000)
+	stream := bytes.Join([][]byte{
+		frame(true, Text, []byte("hello"), []byte{1, 2, 3, 4}),
+		frame(false, Binary, bytes.Repeat([]byte{7}, 300), nil),
+		frame(true, Ping, nil, nil),
+		frame(true, Continuation, large, []byte{9, 9, 9, 9}),
+		frame(true, Close, []byte{0x03, 0xE8}, nil),
+	}, nil)
+
+	tests := []struct {
+		opcode  Opcode
+		fin     bool
+		length  int64
+		size    int64
+		payload string
+		ends    bool
+	}{
+		{opcode: Text, fin: true, length: 5, size: 11, payload: "hello", ends: true},
+		{opcode: Binary, length: 300, size: 304, payload: string(bytes.Repeat([]byte{7}, 16))},
+		{opcode: Ping, fin: true, size: 2},
+		{opcode: Continuation, fin: true, length: 70000, size: 70014, payload: "xxxxxxxxxxxxxxxx", ends: true},
+		{opcode: Close, fin: true, length: 2, size: 4, payload: "\x03\xE8"},
+	}
+	r := bytes.NewReader(stream)
+	for i, tt := range tests {
+		f, err := ReadFrame(r, 16)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if f.Opcode != tt.opcode || f.Fin != tt.fin || f.Length != tt.length || f.Size != tt.size ||
+			string(f.Payload) != tt.payload || f.EndsMessage() != tt.ends {
+			t.Errorf("frame %d = %+v", i, f)
+		}
+	}
+	if _, err := ReadFrame(r, 16); err != io.EOF {
+		t.Errorf("after the last frame err = %v, want EOF", err)
+	}
+	if _, err := ReadFrame(bytes.NewReader(stream[:8]), 16); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated frame err = %v, want ErrUnexpectedEOF", err)
+	}
+}
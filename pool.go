@@ -0,0 +1,198 @@
+package echogy
+
+import (
+	"crypto/subtle"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/youkale/echogy/logger"
+)
+
+// balance policies of a pool, picked with the pool option
+const (
+	roundRobin = "round-robin"
+	leastConn  = "least-conn"
+)
+
+// poolRetryAfter is how long a member whose channel open failed stays out of rotation
+var poolRetryAfter = 10 * time.Second
+
+// tunnelPool is the sessions serving one tunnel name, sessions opened with the same pool
+// option join it and facade connections are spread over them. sessionHub holds one of the
+// members, any member leads to the pool.
+type tunnelPool struct {
+	id     string
+	policy string
+	key    string       // secret of the key option, sessions joining must have it
+	total  atomic.Int64 // connections given to the members
+
+	mu      sync.Mutex
+	members []*forwarder
+	next    int
+	closed  bool // the last member left, the name is free again
+}
+
+func newTunnelPool(id, policy string, first *forwarder) *tunnelPool {
+	p := &tunnelPool{id: id, policy: policy, key: first.opts.key, members: []*forwarder{first}}
+	first.pool = p
+	return p
+}
+
+// join adds a session to the pool, it fails once the pool closed
+func (p *tunnelPool) join(fwd *forwarder) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	fwd.pool = p
+	fwd.poolBase = p.total.Load()
+	p.members = append(p.members, fwd)
+	return true
+}
+
+// leave removes a session from the pool and hands sessionHub to another member,
+// the last one frees the name
+func (p *tunnelPool) leave(fwd *forwarder) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := slices.Index(p.members, fwd)
+	if i < 0 {
+		return
+	}
+	p.members = slices.Delete(p.members, i, i+1)
+	if len(p.members) == 0 {
+		p.closed = true
+		sessionHub.CompareAndDelete(p.id, fwd)
+		return
+	}
+	sessionHub.CompareAndSwap(p.id, fwd, p.members[0])
+}
+
+// drop takes a member out of rotation for poolRetryAfter
+func (p *tunnelPool) drop(fwd *forwarder, reason string) {
+	fwd.droppedAt.Store(time.Now().UnixNano())
+	logger.Warn("pool member dropped", map[string]interface{}{
+		"module":     "pool",
+		"accessId":   p.id,
+		"remoteAddr": fwd.sess.RemoteAddr().String(),
+		"reason":     reason,
+	})
+}
+
+func (fwd *forwarder) inRotation() bool {
+	dropped := fwd.droppedAt.Load()
+	return dropped == 0 || time.Since(time.Unix(0, dropped)) >= poolRetryAfter
+}
+
+// pick chooses the member for a connection among those landing on domain, any domain when
+// empty. Members out of rotation are only picked when every member is out, unless healthy
+// is set, then there is none to pick.
+func (p *tunnelPool) pick(domain string, healthy bool) *forwarder {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var landing, rotation []*forwarder
+	for _, member := range p.members {
+		if domain != "" && !slices.Contains(member.domains, domain) {
+			continue
+		}
+		landing = append(landing, member)
+		if member.inRotation() {
+			rotation = append(rotation, member)
+		}
+	}
+	candidates := rotation
+	if len(candidates) == 0 && !healthy {
+		candidates = landing
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	p.next++
+	picked := candidates[p.next%len(candidates)]
+	if p.policy == leastConn {
+		// ties go round-robin
+		for i := range candidates {
+			member := candidates[(p.next+i)%len(candidates)]
+			if member.active.Load() < picked.active.Load() {
+				picked = member
+			}
+		}
+	}
+	return picked
+}
+
+// snapshot returns the members of the pool
+func (p *tunnelPool) snapshot() []*forwarder {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.members)
+}
+
+// share returns the pool size and the part of the connections given to the pool since the
+// member joined that went to it
+func (p *tunnelPool) share(fwd *forwarder) (int, float64) {
+	p.mu.Lock()
+	size := len(p.members)
+	p.mu.Unlock()
+	total := p.total.Load() - fwd.poolBase
+	if total <= 0 {
+		return size, 0
+	}
+	return size, float64(fwd.poolConns.Load()) / float64(total)
+}
+
+// countConn counts a connection given to the tunnel
+func (fwd *forwarder) countConn() {
+	fwd.connCount.Add(1)
+	if nil != fwd.pool {
+		fwd.poolConns.Add(1)
+		fwd.pool.total.Add(1)
+	}
+}
+
+// releaseTunnel frees the name of a tunnel whose session ended, or takes it out of its pool
+func releaseTunnel(id string, fwd *forwarder) {
+	if nil != fwd.pool {
+		fwd.pool.leave(fwd)
+		return
+	}
+	sessionHub.CompareAndDelete(id, fwd)
+}
+
+// sameKey compares the secrets of key options in constant time, there is no match without one
+func sameKey(a, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// joinable reports whether a tunnel with opts may join the pool of existing, it needs the
+// policy and the key of the pool
+func joinable(existing *forwarder, opts *tunnelOptions) bool {
+	return opts.pool != "" && nil != existing.pool && existing.pool.policy == opts.pool &&
+		sameKey(existing.pool.key, opts.key)
+}
+
+// claimTunnel registers a tunnel under its name. A tunnel opened with the pool option joins
+// the pool already serving the name when the policies and keys match, any other claim of a
+// taken name fails.
+func claimTunnel(id string, fwd *forwarder) bool {
+	for {
+		if fwd.opts.pool != "" {
+			newTunnelPool(id, fwd.opts.pool, fwd)
+		}
+		value, loaded := sessionHub.LoadOrStore(id, fwd)
+		if !loaded {
+			return true
+		}
+		if !joinable(value.(*forwarder), fwd.opts) {
+			fwd.pool = nil
+			return false
+		}
+		if value.(*forwarder).pool.join(fwd) {
+			return true
+		}
+		// the pool closed meanwhile, the name is about to be free
+	}
+}
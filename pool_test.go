@@ -0,0 +1,142 @@
+package echogy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+)
+
+func poolMember(opts *tunnelOptions, domains ...string) *forwarder {
+	return &forwarder{accessId: "pooled", domains: domains, opts: opts}
+}
+
+func TestClaimTunnelPool(t *testing.T) {
+	rr := &tunnelOptions{pool: roundRobin, key: "team-secret"}
+	first, second := poolMember(rr, "webs.sh"), poolMember(rr, "webs.sh")
+	if !claimTunnel("pooled", first) || !claimTunnel("pooled", second) {
+		t.Fatal("members of a pool should claim the same name")
+	}
+	defer sessionHub.Delete("pooled")
+	if claimTunnel("pooled", poolMember(&tunnelOptions{})) {
+		t.Error("a session without the pool option took a pooled name")
+	}
+	if claimTunnel("pooled", poolMember(&tunnelOptions{pool: leastConn, key: "team-secret"})) {
+		t.Error("a session with another policy joined the pool")
+	}
+	if claimTunnel("pooled", poolMember(&tunnelOptions{pool: roundRobin, key: "guessed-it"})) {
+		t.Error("a session with another key joined the pool")
+	}
+	if claimTunnel("pooled", poolMember(&tunnelOptions{pool: roundRobin})) {
+		t.Error("a session without a key joined the pool")
+	}
+	if first.pool != second.pool || len(first.pool.snapshot()) != 2 {
+		t.Fatalf("members don't share a pool of 2")
+	}
+
+	// round-robin alternates, a dropped member is skipped
+	picked := map[*forwarder]int{}
+	for range 4 {
+		fwd, _ := lookupTunnel("pooled", "webs.sh")
+		picked[fwd]++
+	}
+	if picked[first] != 2 || picked[second] != 2 {
+		t.Errorf("round-robin picked %d and %d times, want 2 each", picked[first], picked[second])
+	}
+	first.droppedAt.Store(time.Now().UnixNano())
+	for range 3 {
+		if fwd, _ := lookupTunnel("pooled", "webs.sh"); fwd != second {
+			t.Fatalf("picked a member out of rotation")
+		}
+	}
+	second.droppedAt.Store(time.Now().UnixNano())
+	if fwd := first.pool.pick("", true); nil != fwd {
+		t.Errorf("failover picked a member out of rotation")
+	}
+	if fwd, found := lookupTunnel("pooled", "webs.sh"); !found || nil == fwd {
+		t.Errorf("lookup found no member with every member out of rotation")
+	}
+	second.droppedAt.Store(time.Now().Add(-poolRetryAfter).UnixNano())
+	if fwd := first.pool.pick("", true); fwd != second {
+		t.Errorf("a member wasn't back in rotation after poolRetryAfter")
+	}
+
+	// the name stays with the pool until the last member leaves
+	releaseTunnel("pooled", first)
+	if value, found := sessionHub.Load("pooled"); !found || value != second {
+		t.Fatalf("sessionHub doesn't hold the remaining member")
+	}
+	releaseTunnel("pooled", second)
+	if _, found := sessionHub.Load("pooled"); found {
+		t.Fatalf("the name wasn't freed by the last member")
+	}
+	if second.pool.join(poolMember(rr)) {
+		t.Error("joined a closed pool")
+	}
+}
+
+func TestPoolLeastConn(t *testing.T) {
+	lc := &tunnelOptions{pool: leastConn}
+	busy, idle := poolMember(lc), poolMember(lc)
+	pool := newTunnelPool("lc", leastConn, busy)
+	pool.join(idle)
+	busy.active.Store(3)
+	idle.active.Store(1)
+	for range 3 {
+		if pool.pick("", false) != idle {
+			t.Fatal("least-conn didn't pick the member with the fewest connections")
+		}
+	}
+
+	idle.countConn()
+	busy.countConn()
+	idle.countConn()
+	if size, share := pool.share(idle); size != 2 || share < 0.66 || share > 0.67 {
+		t.Errorf("share() = %d, %.2f, want 2, 0.67", size, share)
+	}
+}
+
+// addrSession is a session known only by its address, for the pool logs
+type addrSession struct {
+	ssh.Session
+}
+
+func (addrSession) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
+func TestPoolFailover(t *testing.T) {
+	lc := &tunnelOptions{pool: leastConn}
+	failed, ended, live := poolMember(lc), poolMember(lc), poolMember(lc)
+	pool := newTunnelPool("failover", leastConn, failed)
+	pool.join(ended)
+	pool.join(live)
+	for _, member := range []*forwarder{failed, ended, live} {
+		member.sess = addrSession{}
+		member.reqChan = make(chan net.Conn)
+		member.context, member.cancelFunc = context.WithCancel(context.Background())
+	}
+	defer live.cancelFunc()
+	// the session of ended is over, its serve loop no longer reads its connections, being
+	// idle it is picked first
+	ended.cancelFunc()
+	live.active.Store(1)
+	served := make(chan net.Conn, 1)
+	go func() { served <- <-live.reqChan }()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	if !failed.failover(newHijackConn(server), "refused") {
+		t.Fatal("failover found no member")
+	}
+	if <-served == nil || live.active.Load() != 2 || ended.active.Load() != 0 || ended.inRotation() {
+		t.Errorf("failover didn't fall through the ended member to the live one")
+	}
+
+	live.cancelFunc()
+	if failed.failover(newHijackConn(server), "refused") {
+		t.Error("failover handed the connection to an ended member")
+	}
+}
@@ -0,0 +1,223 @@
+package echogy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+
+	"github.com/youkale/echogy/logger"
+	"github.com/youkale/echogy/pkg/capture"
+	"github.com/youkale/echogy/pkg/errorpage"
+	"github.com/youkale/echogy/tui"
+	"golang.org/x/net/http2"
+)
+
+// The raw facade path pipes HTTP/1.x connections to the tunnel untouched. Requests that
+// can't take it, like the streams of an HTTP/2 connection, are proxied one by one through
+// tunnelHandler, over forwarded-tcpip channels opened by the forwarder's transport.
+
+// originKey carries the facade client address to the channel dialer
+type originKey struct{}
+
+// h2Server serves HTTP/2 facade connections, over TLS with ALPN or as h2c with prior knowledge
+var h2Server = &http2.Server{
+	IdleTimeout: 2 * time.Minute,
+}
+
+func serveH2(conn net.Conn, router *hostRouter) {
+	h2Server.ServeConn(conn, &http2.ServeConnOpts{
+		BaseConfig: &http.Server{
+			MaxHeaderBytes:    limits.maxHeaderBytes,
+			ReadHeaderTimeout: limits.readHeaderTimeout,
+		},
+		Handler: tunnelHandler(router),
+	})
+}
+
+// tunnelHandler proxies each request to the tunnel named by its host, the :authority for HTTP/2
+func tunnelHandler(router *hostRouter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host == "" {
+			writeErrorPage(w, r, http.StatusBadRequest, "The request has no Host header.")
+			return
+		}
+		id, domain, ok := router.route(r.Host)
+		if !ok {
+			writeErrorPage(w, r, http.StatusMisdirectedRequest, "")
+			return
+		}
+		id = splitRoute(id, r)
+		fwd, found := lookupTunnel(pathRoute(id, r.URL.Path), domain)
+		if !found {
+			requestId := writeErrorPage(w, r, http.StatusNotFound, fmt.Sprintf("Tunnel %s not found.", id))
+			logger.Warn("not found forward", map[string]interface{}{
+				"module":    "proxy",
+				"method":    r.Method,
+				"accessId":  id,
+				"url":       r.URL.String(),
+				"requestId": requestId,
+			})
+			return
+		}
+		fwd.proxy(w, r)
+	})
+}
+
+// roundTripper returns the transport carrying proxied requests over forwarded-tcpip channels,
+// as HTTP/1.1, or as h2c when the tunnel was opened with h2=on
+func (fwd *forwarder) roundTripper() http.RoundTripper {
+	fwd.transportOnce.Do(func() {
+		dial := func(ctx context.Context) (net.Conn, error) {
+			origin, _ := ctx.Value(originKey{}).(string)
+			if origin == "" {
+				origin = fwd.sess.LocalAddr().String()
+			}
+			conn, err := fwd.openChannel(origin)
+			if err != nil {
+				if nil != fwd.pool {
+					// the next requests go to the other members
+					_, reason := channelFailure(err)
+					fwd.pool.drop(fwd, reason)
+				}
+				return nil, err
+			}
+			return countReads(conn, &fwd.bytesOut, &totalBytesOut).countWrites(&fwd.bytesIn, &totalBytesIn), nil
+		}
+		var transport interface {
+			http.RoundTripper
+			CloseIdleConnections()
+		}
+		if fwd.opts.h2 {
+			transport = &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, _, _ string, _ *tls.Config) (net.Conn, error) {
+					return dial(ctx)
+				},
+			}
+		} else {
+			transport = &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dial(ctx)
+				},
+				MaxIdleConnsPerHost: 16,
+				IdleConnTimeout:     90 * time.Second,
+			}
+		}
+		context.AfterFunc(fwd.context, transport.CloseIdleConnections)
+		fwd.transport = transport
+	})
+	return fwd.transport
+}
+
+// proxy sends a single request through the tunnel and reports the exchange to the dashboard
+func (fwd *forwarder) proxy(w http.ResponseWriter, r *http.Request) {
+	if fwd.opts.cors.isPreflight(r) {
+		fwd.answerPreflight(w, r)
+		return
+	}
+	fwd.countConn()
+	fwd.active.Add(1)
+	defer fwd.active.Add(-1)
+	startTime := time.Now()
+
+	var reqBody, respBody *capture.Buffer
+	if nil != fwd.pty && fwd.opts.capture {
+		reqBody = capture.New(capture.DefaultLimit)
+		respBody = capture.New(capture.DefaultLimit)
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Transport: fwd.roundTripper(),
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetXForwarded()
+			pr.Out.URL.Scheme = "http"
+			pr.Out.URL.Host = r.Host
+			pr.Out.Host = r.Host
+			if fwd.path != "" && fwd.opts.strip {
+				stripPrefix(pr.Out, fwd.path)
+			}
+			if fwd.opts.compress {
+				askCompressed(pr.Out)
+			}
+			fwd.opts.headers.rewriteRequest(pr.Out)
+			if nil != reqBody && nil != pr.Out.Body && http.NoBody != pr.Out.Body {
+				pr.Out.Body = &teeBody{Reader: io.TeeReader(pr.Out.Body, reqBody), Closer: pr.Out.Body}
+			}
+		},
+		ModifyResponse: func(resp *http.Response) error {
+			var compression *tui.Compression
+			if fwd.opts.compress {
+				compression = recode(r, resp, nil != fwd.pty)
+			}
+			fwd.opts.cors.decorate(r, resp)
+			fwd.opts.headers.rewriteResponse(resp)
+			if nil == fwd.pty {
+				return nil
+			}
+			body := &observedBody{ReadCloser: resp.Body, capture: orDiscard(respBody)}
+			body.done = func() {
+				fwd.pty.Notify(&tui.Exchange{
+					Response:     resp,
+					Request:      r,
+					UseTime:      time.Since(startTime).Milliseconds(),
+					RequestBody:  reqBody,
+					ResponseBody: respBody,
+					StartTime:    startTime,
+					Compression:  compression,
+				})
+			}
+			resp.Body = body
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			status, reason := channelFailure(err)
+			page := errorpage.NewPage(status, "")
+			// the page is rendered once, the client and the dashboard get the same response
+			resp := errorPages.Response(r, page)
+			errorPages.WriteResponse(w, resp)
+			logger.Warn("proxy request", map[string]interface{}{
+				"module":    "proxy",
+				"accessId":  fwd.accessId,
+				"url":       r.URL.String(),
+				"error":     err.Error(),
+				"requestId": page.RequestID,
+			})
+			fwd.notifyFailure(r, resp, reason, startTime)
+		},
+	}
+	proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), originKey{}, r.RemoteAddr)))
+}
+
+type teeBody struct {
+	io.Reader
+	io.Closer
+}
+
+// observedBody captures a response body as it is copied to the client, done runs
+// once when the last byte was read or the body closed
+type observedBody struct {
+	io.ReadCloser
+	capture io.Writer
+	done    func()
+	once    sync.Once
+}
+
+func (o *observedBody) Read(b []byte) (int, error) {
+	n, err := o.ReadCloser.Read(b)
+	o.capture.Write(b[:n])
+	if err == io.EOF {
+		o.once.Do(o.done)
+	}
+	return n, err
+}
+
+func (o *observedBody) Close() error {
+	o.once.Do(o.done)
+	return o.ReadCloser.Close()
+}
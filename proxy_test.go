@@ -0,0 +1,65 @@
+package echogy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestFacadeH2C(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+		io.WriteString(w, r.Host+r.URL.Path)
+	}))
+	defer backend.Close()
+
+	fwd := &forwarder{accessId: "h2c", domains: []string{"webs.sh"}, opts: &tunnelOptions{capture: true}}
+	fwd.transportOnce.Do(func() {
+		fwd.transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return net.Dial("tcp", backend.Listener.Addr().String())
+			},
+		}
+	})
+	sessionHub.Store(fwd.accessId, fwd)
+	defer sessionHub.Delete(fwd.accessId)
+
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, _, _ string, _ *tls.Config) (net.Conn, error) {
+			c, s := net.Pipe()
+			go handleConnection(s, newHostRouter(1, "webs.sh"), func(string, string, *hijackConn) bool { return false })
+			return c, nil
+		},
+	}}
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{url: "http://h2c.webs.sh/hello", status: http.StatusOK, body: "h2c.webs.sh/hello"},
+		{url: "http://gone.webs.sh/", status: http.StatusNotFound, body: "404 Not Found\n\nTunnel gone not found.\n\nRequest ID: "},
+	}
+	for _, tt := range tests {
+		resp, err := client.Get(tt.url)
+		if err != nil {
+			t.Fatalf("GET %s: %v", tt.url, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.ProtoMajor != 2 || resp.StatusCode != tt.status || !strings.HasPrefix(string(body), tt.body) {
+			t.Errorf("GET %s = %s %d %q", tt.url, resp.Proto, resp.StatusCode, body)
+		}
+		if resp.StatusCode == http.StatusOK && resp.Header.Get("X-Proto") != "HTTP/1.1" {
+			t.Errorf("the tunnel got %s, want HTTP/1.1", resp.Header.Get("X-Proto"))
+		}
+	}
+}
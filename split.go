@@ -0,0 +1,412 @@
+package echogy
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/youkale/echogy/logger"
+)
+
+// sticky modes of a split
+const (
+	stickyIP     = "ip"
+	stickyCookie = "cookie"
+)
+
+// Split sends the traffic of a tunnel name to other tunnels by weight, e.g. 90% of app to
+// app-stable and 10% to app-canary
+type Split struct {
+	Name string `json:"name"`
+	// Sticky keeps a client on the same target, by "ip" or by the value of a "cookie"
+	Sticky  string        `json:"sticky,omitempty"`
+	Cookie  string        `json:"cookie,omitempty"` // cookie picking the target with sticky cookie, the client ip when it is missing
+	Targets []SplitTarget `json:"targets"`
+}
+
+// SplitTarget is a tunnel taking a share of a split
+type SplitTarget struct {
+	Tunnel   string `json:"tunnel"`
+	Weight   int    `json:"weight"`
+	Requests int64  `json:"requests"` // connections the split sent to the tunnel, ignored when setting the split
+}
+
+// splits holds the traffic splits by name, they are consulted before sessionHub
+var splits sync.Map
+
+type trafficSplit struct {
+	Split
+	requests []atomic.Int64 // by target
+	key      string         // secret of the split set over SSH, its targets are opened with it, empty for the admin API
+}
+
+func (s *Split) validate() error {
+	if !validAccessId(s.Name) {
+		return fmt.Errorf("%q is not a tunnel name", s.Name)
+	}
+	switch s.Sticky {
+	case "", stickyIP:
+	case stickyCookie:
+		if s.Cookie == "" {
+			return errors.New("sticky cookie needs the name of the cookie")
+		}
+	default:
+		return fmt.Errorf("sticky %q must be %s or %s", s.Sticky, stickyIP, stickyCookie)
+	}
+	if len(s.Targets) == 0 {
+		return errors.New("a split needs targets")
+	}
+	total := 0
+	seen := map[string]bool{}
+	for _, target := range s.Targets {
+		switch {
+		case !validAccessId(target.Tunnel):
+			return fmt.Errorf("target %q is not a tunnel name", target.Tunnel)
+		case target.Tunnel == s.Name:
+			return fmt.Errorf("target %s is the split itself", target.Tunnel)
+		case seen[target.Tunnel]:
+			return fmt.Errorf("target %s is listed twice", target.Tunnel)
+		case target.Weight < 0:
+			return fmt.Errorf("target %s: weight %d is negative", target.Tunnel, target.Weight)
+		}
+		seen[target.Tunnel] = true
+		total += target.Weight
+	}
+	if total == 0 {
+		return errors.New("the weights add up to 0")
+	}
+	return nil
+}
+
+// setSplit validates and stores a split owned by key, the request counts of the targets it had are kept
+func setSplit(s Split, key string) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	split := &trafficSplit{Split: s, requests: make([]atomic.Int64, len(s.Targets)), key: key}
+	split.Targets = make([]SplitTarget, len(s.Targets))
+	for i, target := range s.Targets {
+		split.Targets[i] = SplitTarget{Tunnel: target.Tunnel, Weight: target.Weight}
+	}
+	if value, found := splits.Load(s.Name); found {
+		previous := value.(*trafficSplit)
+		for i, target := range split.Targets {
+			for j, old := range previous.Targets {
+				if old.Tunnel == target.Tunnel {
+					split.requests[i].Store(previous.requests[j].Load())
+				}
+			}
+		}
+	}
+	splits.Store(s.Name, split)
+	logger.Info("split set", map[string]interface{}{
+		"module": "split",
+		"name":   s.Name,
+		"split":  split.summary(),
+	})
+	return nil
+}
+
+func deleteSplit(name string) bool {
+	_, found := splits.LoadAndDelete(name)
+	return found
+}
+
+// status returns the split with its request counts
+func (s *trafficSplit) status() Split {
+	status := s.Split
+	status.Targets = make([]SplitTarget, len(s.Targets))
+	for i, target := range s.Targets {
+		target.Requests = s.requests[i].Load()
+		status.Targets[i] = target
+	}
+	return status
+}
+
+func splitStatuses() []Split {
+	statuses := make([]Split, 0)
+	splits.Range(func(key, value any) bool {
+		statuses = append(statuses, value.(*trafficSplit).status())
+		return true
+	})
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// summary renders the targets on one line, like "app-stable 90% 120 req · app-canary 10% 13 req"
+func (s *trafficSplit) summary() string {
+	total := 0
+	for _, target := range s.Targets {
+		total += target.Weight
+	}
+	parts := make([]string, len(s.Targets))
+	for i, target := range s.Targets {
+		parts[i] = fmt.Sprintf("%s %d%% %d req", target.Tunnel, target.Weight*100/total, s.requests[i].Load())
+	}
+	summary := strings.Join(parts, " · ")
+	if s.Sticky != "" {
+		summary += " (sticky " + s.Sticky + ")"
+	}
+	return summary
+}
+
+// stickyKey is what keeps a client on its target
+func (s *trafficSplit) stickyKey(req *http.Request) string {
+	if s.Sticky == stickyCookie {
+		if cookie, err := req.Cookie(s.Cookie); err == nil && cookie.Value != "" {
+			return cookie.Value
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// serves reports whether a target tunnel is live, and opened with the key of the split when
+// it was set over SSH
+func (s *trafficSplit) serves(tunnel string) bool {
+	value, found := sessionHub.Load(tunnel)
+	return found && (s.key == "" || sameKey(value.(*forwarder).opts.key, s.key))
+}
+
+// live reports whether a target of the split is live
+func (s *trafficSplit) live() bool {
+	for _, target := range s.Targets {
+		if s.serves(target.Tunnel) {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseSplits removes the splits set over SSH once none of their targets is live, they last
+// as long as the sessions they send traffic to
+func releaseSplits() {
+	splits.Range(func(name, value any) bool {
+		if split := value.(*trafficSplit); split.key != "" && !split.live() && splits.CompareAndDelete(name, value) {
+			logger.Info("split released", map[string]interface{}{
+				"module": "split",
+				"name":   name,
+			})
+		}
+		return true
+	})
+}
+
+// pick chooses the target of a request among the live tunnels by weight, -1 when none is live
+func (s *trafficSplit) pick(req *http.Request) int {
+	total := 0
+	live := make([]bool, len(s.Targets))
+	for i, target := range s.Targets {
+		if target.Weight > 0 && s.serves(target.Tunnel) {
+			live[i] = true
+			total += target.Weight
+		}
+	}
+	if total == 0 {
+		return -1
+	}
+	var n int
+	if s.Sticky != "" && nil != req {
+		h := fnv.New32a()
+		io.WriteString(h, s.stickyKey(req))
+		n = int(h.Sum32() % uint32(total))
+	} else {
+		n = rand.IntN(total)
+	}
+	for i, target := range s.Targets {
+		if !live[i] {
+			continue
+		}
+		if n < target.Weight {
+			return i
+		}
+		n -= target.Weight
+	}
+	return -1
+}
+
+// splitRoute returns the tunnel a request for id goes to, id itself unless a split with live
+// targets has the name
+func splitRoute(id string, req *http.Request) string {
+	value, found := splits.Load(id)
+	if !found {
+		return id
+	}
+	split := value.(*trafficSplit)
+	i := split.pick(req)
+	if i < 0 {
+		if split.key != "" {
+			// its targets went away, a target leaving while it was set included
+			splits.CompareAndDelete(id, value)
+		}
+		return id
+	}
+	split.requests[i].Add(1)
+	return split.Targets[i].Tunnel
+}
+
+// splitsOf renders the splits sending traffic to the tunnel, for its dashboard
+func splitsOf(fwd *forwarder) []string {
+	var lines []string
+	splits.Range(func(key, value any) bool {
+		split := value.(*trafficSplit)
+		if split.key != "" && !sameKey(fwd.opts.key, split.key) {
+			return true
+		}
+		for _, target := range split.Targets {
+			if target.Tunnel == fwd.name {
+				lines = append(lines, split.Name+": "+split.summary())
+				break
+			}
+		}
+		return true
+	})
+	sort.Strings(lines)
+	return lines
+}
+
+// parseSplitCommand parses the arguments of `split <name> <tunnel>=<weight>... [sticky=ip|cookie:<name>]`
+func parseSplitCommand(name string, args []string) (Split, error) {
+	split := Split{Name: name}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return split, fmt.Errorf("%q must be <tunnel>=<weight> or sticky=<mode>", arg)
+		}
+		if key == "sticky" {
+			split.Sticky, split.Cookie, _ = strings.Cut(value, ":")
+			continue
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil {
+			return split, fmt.Errorf("%s: weight %q is not a number", key, value)
+		}
+		split.Targets = append(split.Targets, SplitTarget{Tunnel: key, Weight: weight})
+	}
+	return split, split.validate()
+}
+
+// splitKey takes the key=<secret> argument out of the arguments of the split command
+func splitKey(args []string) (string, []string) {
+	key, rest := "", make([]string, 0, len(args))
+	for _, arg := range args {
+		if value, found := strings.CutPrefix(arg, "key="); found {
+			key = value
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return key, rest
+}
+
+// maxSplitsPerKey bounds the splits set over SSH with one key
+const maxSplitsPerKey = 16
+
+// splitsWithKey counts the splits set with key
+func splitsWithKey(key string) int {
+	n := 0
+	splits.Range(func(_, value any) bool {
+		if sameKey(value.(*trafficSplit).key, key) {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// splitCommand runs `ssh webs.sh split ... key=<secret>`, it shows, sets or removes (with off)
+// the splits set with the key. Their targets must be tunnels opened with the same key, and the
+// name of a live tunnel can't be split, its traffic isn't the caller's to send elsewhere. The
+// split goes away with the last of its targets.
+func splitCommand(w io.Writer, args []string) int {
+	key, args := splitKey(args)
+	if _, err := parseKey("key", key); err != nil {
+		fmt.Fprintf(w, "split needs key=<secret>, the key of the tunnels it sends traffic to: %v\n", err)
+		return 1
+	}
+	if len(args) == 0 {
+		for _, split := range splitStatuses() {
+			if value, found := splits.Load(split.Name); found && sameKey(value.(*trafficSplit).key, key) {
+				fmt.Fprintf(w, "%s\n", formatSplit(split))
+			}
+		}
+		return 0
+	}
+	name, args := strings.ToLower(args[0]), args[1:]
+	value, found := splits.Load(name)
+	owned := found && sameKey(value.(*trafficSplit).key, key)
+	switch {
+	case len(args) == 0:
+		if !owned {
+			fmt.Fprintf(w, "no split %s\n", name)
+			return 1
+		}
+		fmt.Fprintf(w, "%s\n", formatSplit(value.(*trafficSplit).status()))
+		return 0
+	case len(args) == 1 && args[0] == "off":
+		if !owned || !splits.CompareAndDelete(name, value) {
+			fmt.Fprintf(w, "no split %s\n", name)
+			return 1
+		}
+		fmt.Fprintf(w, "split %s removed\n", name)
+		return 0
+	case found && !owned:
+		fmt.Fprintf(w, "split %s was set with another key\n", name)
+		return 1
+	case !found && splitsWithKey(key) >= maxSplitsPerKey:
+		fmt.Fprintf(w, "split %s: %d splits are set with this key, remove one first\n", name, maxSplitsPerKey)
+		return 1
+	}
+	if _, found := sessionHub.Load(name); found {
+		fmt.Fprintf(w, "%s is a tunnel, it can't be split\n", name)
+		return 1
+	}
+	split, err := parseSplitCommand(name, args)
+	if err == nil {
+		for _, target := range split.Targets {
+			if value, live := sessionHub.Load(target.Tunnel); !live || !sameKey(value.(*forwarder).opts.key, key) {
+				err = fmt.Errorf("target %s isn't a tunnel opened with key=<secret>", target.Tunnel)
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = setSplit(split, key)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "split %s: %v\n", name, err)
+		return 1
+	}
+	value, _ = splits.Load(name)
+	fmt.Fprintf(w, "%s\n", formatSplit(value.(*trafficSplit).status()))
+	return 0
+}
+
+func formatSplit(split Split) string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%s", split.Name)
+	if split.Sticky != "" {
+		fmt.Fprintf(b, " sticky=%s", split.Sticky)
+		if split.Cookie != "" {
+			fmt.Fprintf(b, ":%s", split.Cookie)
+		}
+	}
+	for _, target := range split.Targets {
+		fmt.Fprintf(b, "\n  %-24s weight %-4d %d requests", target.Tunnel, target.Weight, target.Requests)
+	}
+	return b.String()
+}
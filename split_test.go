@@ -0,0 +1,178 @@
+package echogy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSplitRoute(t *testing.T) {
+	stable, canary := &forwarder{accessId: "app-stable"}, &forwarder{accessId: "app-canary"}
+	sessionHub.Store(stable.accessId, stable)
+	defer sessionHub.Delete(stable.accessId)
+
+	split, err := parseSplitCommand("app", []string{"app-stable=90", "app-canary=10", "sticky=ip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setSplit(split, ""); err != nil {
+		t.Fatal(err)
+	}
+	defer deleteSplit("app")
+
+	req := &http.Request{RemoteAddr: "203.0.113.7:51000", Header: http.Header{}}
+	// the canary isn't connected, its share goes to the live target
+	for range 20 {
+		if got := splitRoute("app", req); got != "app-stable" {
+			t.Fatalf("splitRoute() = %s with the canary down", got)
+		}
+	}
+
+	sessionHub.Store(canary.accessId, canary)
+	defer sessionHub.Delete(canary.accessId)
+	first := splitRoute("app", req)
+	for port := range 20 {
+		// another connection from the same client
+		req.RemoteAddr = fmt.Sprintf("203.0.113.7:%d", 52000+port)
+		if got := splitRoute("app", req); got != first {
+			t.Fatalf("sticky split moved a client from %s to %s", first, got)
+		}
+	}
+	counts := map[string]int{}
+	for i := range 1000 {
+		client := &http.Request{RemoteAddr: fmt.Sprintf("10.0.%d.%d:4000", i/250, i%250), Header: http.Header{}}
+		counts[splitRoute("app", client)]++
+	}
+	if counts["app-canary"] < 50 || counts["app-canary"] > 150 {
+		t.Errorf("canary got %d of 1000 requests, want about 100", counts["app-canary"])
+	}
+	setSplit(Split{Name: "app", Sticky: stickyCookie, Cookie: "uid", Targets: split.Targets}, "")
+	withCookie := func(addr string) *http.Request {
+		r := &http.Request{RemoteAddr: addr, Header: http.Header{}}
+		r.AddCookie(&http.Cookie{Name: "uid", Value: "user-42"})
+		return r
+	}
+	first = splitRoute("app", withCookie("192.0.2.1:1000"))
+	for i := range 20 {
+		if got := splitRoute("app", withCookie(fmt.Sprintf("192.0.2.%d:1000", i+2))); got != first {
+			t.Fatalf("sticky cookie moved a client from %s to %s", first, got)
+		}
+	}
+	if got := splitRoute("other", req); got != "other" {
+		t.Errorf("splitRoute() = %s for a name without a split", got)
+	}
+
+	// replacing the split keeps the counts of its targets
+	value, _ := splits.Load("app")
+	before := value.(*trafficSplit).status().Targets[0].Requests
+	setSplit(Split{Name: "app", Targets: []SplitTarget{{Tunnel: "app-stable", Weight: 1}}}, "")
+	value, _ = splits.Load("app")
+	if after := value.(*trafficSplit).status().Targets[0].Requests; after != before {
+		t.Errorf("requests after replacing = %d, want %d", after, before)
+	}
+}
+
+func TestSplitValidate(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr bool
+	}{
+		{args: []string{"a=1", "b=3"}},
+		{args: []string{"a=1", "sticky=cookie:session"}},
+		{args: []string{"a=1", "sticky=cookie"}, wantErr: true},
+		{args: []string{"a=1", "sticky=random"}, wantErr: true},
+		{args: []string{"a=0"}, wantErr: true},
+		{args: []string{"a=-1", "b=2"}, wantErr: true},
+		{args: []string{"a=1", "a=2"}, wantErr: true},
+		{args: []string{"app=1"}, wantErr: true},
+		{args: []string{"a=ten"}, wantErr: true},
+		{args: []string{"a"}, wantErr: true},
+		{args: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		if _, err := parseSplitCommand("app", tt.args); (err != nil) != tt.wantErr {
+			t.Errorf("parseSplitCommand(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	const key = "team-secret"
+	for _, fwd := range []*forwarder{
+		{accessId: "taken", name: "taken", opts: &tunnelOptions{key: key}},
+		{accessId: "a", name: "a", opts: &tunnelOptions{key: key}},
+		{accessId: "b", name: "b", opts: &tunnelOptions{key: key}},
+		{accessId: "theirs", name: "theirs", opts: &tunnelOptions{key: "other-secret"}},
+	} {
+		sessionHub.Store(fwd.accessId, fwd)
+		defer sessionHub.Delete(fwd.accessId)
+	}
+
+	if code := splitCommand(io.Discard, []string{"web", "a=1", "b=1"}); code == 0 {
+		t.Error("split without a key")
+	}
+	if code := splitCommand(io.Discard, []string{"taken", "a=1", "key=" + key}); code == 0 {
+		t.Error("split the name of a live tunnel")
+	}
+	if code := splitCommand(io.Discard, []string{"web", "a=1", "theirs=1", "key=" + key}); code == 0 {
+		t.Error("split to a tunnel opened with another key")
+	}
+	if code := splitCommand(io.Discard, []string{"web", "a=1", "gone=1", "key=" + key}); code == 0 {
+		t.Error("split to a tunnel that isn't connected")
+	}
+	if code := splitCommand(io.Discard, []string{"web", "a=1", "b=1", "key=" + key}); code != 0 {
+		t.Fatal("split command failed")
+	}
+	if _, found := splits.Load("web"); !found {
+		t.Fatal("split wasn't stored")
+	}
+
+	// the split is only seen and changed with its key
+	out := &strings.Builder{}
+	splitCommand(out, []string{"key=other-secret"})
+	if out.Len() != 0 {
+		t.Errorf("listed the splits of another key: %q", out)
+	}
+	for _, args := range [][]string{{"web"}, {"web", "off"}, {"web", "theirs=1"}} {
+		if code := splitCommand(io.Discard, append(args, "key=other-secret")); code == 0 {
+			t.Errorf("split %q with another key succeeded", args)
+		}
+	}
+	if _, found := splits.Load("web"); !found {
+		t.Fatal("split was removed with another key")
+	}
+
+	// a target taken over by a session with another key gets no traffic
+	sessionHub.Store("b", &forwarder{accessId: "b", name: "b", opts: &tunnelOptions{key: "other-secret"}})
+	for range 20 {
+		if got := splitRoute("web", nil); got != "a" {
+			t.Fatalf("splitRoute() = %s, sent traffic to a tunnel with another key", got)
+		}
+	}
+
+	if code := splitCommand(io.Discard, []string{"web", "off", "key=" + key}); code != 0 {
+		t.Error("removing the split failed")
+	}
+	if _, found := splits.Load("web"); found {
+		t.Error("split wasn't removed")
+	}
+
+	// a key sets a bounded number of splits
+	for i := range maxSplitsPerKey {
+		if code := splitCommand(io.Discard, []string{fmt.Sprintf("web%d", i), "a=1", "key=" + key}); code != 0 {
+			t.Fatalf("split %d failed", i)
+		}
+	}
+	if code := splitCommand(io.Discard, []string{"one-more", "a=1", "key=" + key}); code == 0 {
+		t.Error("set more splits than a key may")
+	}
+
+	// the splits go away with their last target
+	sessionHub.Delete("a")
+	releaseSplits()
+	if n := splitsWithKey(key); n != 0 {
+		t.Errorf("%d splits left without a live target", n)
+	}
+}
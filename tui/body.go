@@ -0,0 +1,154 @@
+package tui
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/andybalholm/brotli"
+	"github.com/youkale/echogy/pkg/capture"
+)
+
+// maxHexDump limits how much of a binary body is shown as hex
+const maxHexDump = 4 << 10
+
+// maxDecodedBody bounds what a captured body decodes to, a small body may be a compression bomb
+const maxDecodedBody = 512 << 10
+
+// errDecodedTruncated marks a decoded body cut at maxDecodedBody
+var errDecodedTruncated = fmt.Errorf("decoded body cut at %s", HumanBytes(maxDecodedBody))
+
+// readPartial reads r until EOF or the first error, keeping what was decoded so far,
+// captured bodies are often truncated so a decoding error is expected at the end
+func readPartial(r io.Reader) []byte {
+	out, _ := io.ReadAll(r)
+	return out
+}
+
+// readDecoded reads a decoder like readPartial, up to maxDecodedBody
+func readDecoded(r io.Reader) ([]byte, error) {
+	out := readPartial(io.LimitReader(r, maxDecodedBody+1))
+	if len(out) > maxDecodedBody {
+		return out[:maxDecodedBody], errDecodedTruncated
+	}
+	return out, nil
+}
+
+// decodeContent removes the content encodings listed in the Content-Encoding header
+func decodeContent(data []byte, contentEncoding string) ([]byte, error) {
+	if contentEncoding == "" {
+		return data, nil
+	}
+	encodings := strings.Split(contentEncoding, ",")
+	var err error
+	// encodings are listed in the order they were applied
+	for i := len(encodings) - 1; i >= 0 && err == nil; i-- {
+		switch enc := strings.ToLower(strings.TrimSpace(encodings[i])); enc {
+		case "", "identity":
+		case "gzip", "x-gzip":
+			r, gzErr := gzip.NewReader(bytes.NewReader(data))
+			if gzErr != nil {
+				return data, gzErr
+			}
+			data, err = readDecoded(r)
+		case "deflate":
+			// deflate is zlib wrapped per the RFC, though some servers send raw deflate
+			if r, zErr := zlib.NewReader(bytes.NewReader(data)); zErr == nil {
+				data, err = readDecoded(r)
+			} else {
+				data, err = readDecoded(flate.NewReader(bytes.NewReader(data)))
+			}
+		case "br":
+			data, err = readDecoded(brotli.NewReader(bytes.NewReader(data)))
+		default:
+			return data, fmt.Errorf("unsupported content encoding %q", enc)
+		}
+	}
+	return data, err
+}
+
+// dechunk removes the chunked transfer coding from captured wire bytes
+func dechunk(raw []byte, transferEncoding []string) []byte {
+	for _, te := range transferEncoding {
+		if strings.EqualFold(te, "chunked") {
+			raw = readPartial(httputil.NewChunkedReader(bytes.NewReader(raw)))
+		}
+	}
+	return raw
+}
+
+// decodeBody turns captured wire bytes into the entity body
+func decodeBody(raw []byte, transferEncoding []string, header http.Header) ([]byte, error) {
+	raw = dechunk(raw, transferEncoding)
+	return decodeContent(raw, header.Get("Content-Encoding"))
+}
+
+func isBinary(data []byte) bool {
+	return !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0
+}
+
+// formatBody pretty prints a decoded body according to its media type
+func formatBody(data []byte, contentType string) string {
+	mediatype, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.Contains(mediatype, "json"):
+		out := &bytes.Buffer{}
+		if err := json.Indent(out, data, "", "  "); err == nil {
+			return out.String()
+		}
+	case mediatype == "application/x-www-form-urlencoded":
+		if values, err := url.ParseQuery(string(data)); err == nil {
+			b := &strings.Builder{}
+			renderQuery(b, values)
+			return strings.TrimRight(b.String(), "\n")
+		}
+	}
+	if isBinary(data) {
+		dump := hex.Dump(data[:min(len(data), maxHexDump)])
+		if len(data) > maxHexDump {
+			dump += fmt.Sprintf("... %d more bytes", len(data)-maxHexDump)
+		}
+		return strings.TrimRight(dump, "\n")
+	}
+	return string(data)
+}
+
+// renderBody renders a captured body section
+func renderBody(b *strings.Builder, title string, body *capture.Buffer, transferEncoding []string, header http.Header) {
+	if body == nil {
+		b.WriteString(sectionStyle.Render(title) + "\n")
+		b.WriteString(hintStyle.Render("  capture disabled for this tunnel") + "\n")
+		return
+	}
+
+	total := body.Total()
+	summary := HumanBytes(total)
+	if body.Truncated() {
+		summary = fmt.Sprintf("first %s of %s", HumanBytes(int64(body.Len())), summary)
+	}
+	b.WriteString(sectionStyle.Render(fmt.Sprintf("%s (%s)", title, summary)) + "\n")
+	if total == 0 {
+		b.WriteString(hintStyle.Render("  (empty)") + "\n")
+		return
+	}
+
+	data, err := decodeBody(body.Bytes(), transferEncoding, header)
+	if err != nil {
+		b.WriteString(hintStyle.Render("  "+err.Error()) + "\n")
+	}
+	if encoding := header.Get("Content-Encoding"); encoding != "" && (err == nil || err == errDecodedTruncated) {
+		b.WriteString(hintStyle.Render(fmt.Sprintf("  decoded from %s", encoding)) + "\n")
+	}
+	b.WriteString(formatBody(data, header.Get("Content-Type")) + "\n")
+}
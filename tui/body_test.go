@@ -0,0 +1,97 @@
+package tui
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func gzipped(s string) []byte {
+	b := &bytes.Buffer{}
+	w := gzip.NewWriter(b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.Bytes()
+}
+
+func brotlied(s string) []byte {
+	b := &bytes.Buffer{}
+	w := brotli.NewWriter(b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.Bytes()
+}
+
+func TestDecodeBody(t *testing.T) {
+	tests := []struct {
+		name             string
+		raw              []byte
+		transferEncoding []string
+		contentEncoding  string
+		want             string
+		wantErr          bool
+	}{
+		{name: "identity", raw: []byte("hello"), want: "hello"},
+		{name: "chunked", raw: []byte("5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"), transferEncoding: []string{"chunked"}, want: "hello world"},
+		{name: "truncated chunked", raw: []byte("5\r\nhello\r\n6\r\n wo"), transferEncoding: []string{"chunked"}, want: "hello wo"},
+		{name: "gzip", raw: gzipped("compressed"), contentEncoding: "gzip", want: "compressed"},
+		{name: "br", raw: brotlied("compressed"), contentEncoding: "br", want: "compressed"},
+		{name: "unknown encoding", raw: []byte("zzz"), contentEncoding: "zstd", want: "zzz", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.contentEncoding != "" {
+				header.Set("Content-Encoding", tt.contentEncoding)
+			}
+			got, err := decodeBody(tt.raw, tt.transferEncoding, header)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeBody() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("decodeBody() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeBodyBomb(t *testing.T) {
+	bomb := gzipped(strings.Repeat("\x00", 64<<20))
+	header := http.Header{}
+	header.Set("Content-Encoding", "gzip")
+	got, err := decodeBody(bomb, nil, header)
+	if err != errDecodedTruncated || len(got) != maxDecodedBody {
+		t.Errorf("decodeBody() = %d bytes, %v, want the first %d bytes marked as truncated", len(got), err, maxDecodedBody)
+	}
+}
+
+func TestFormatBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		contentType string
+		contains    []string
+	}{
+		{name: "json", data: []byte(`{"a":1,"b":[true]}`), contentType: "application/json; charset=utf-8", contains: []string{"{\n  \"a\": 1,"}},
+		{name: "invalid json", data: []byte(`{"a":`), contentType: "application/json", contains: []string{`{"a":`}},
+		{name: "form", data: []byte("name=echogy&tag=a&tag=b"), contentType: "application/x-www-form-urlencoded", contains: []string{"name =", "echogy", "tag =", "b"}},
+		{name: "text", data: []byte("plain text"), contentType: "text/plain", contains: []string{"plain text"}},
+		{name: "binary", data: []byte{0x00, 0x01, 0xff}, contentType: "application/octet-stream", contains: []string{"00 01 ff"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := formatBody(tt.data, tt.contentType)
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("formatBody() = %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Compression is how a response body was encoded on the tunnel and for the client, it is
+// counted while the body is copied
+type Compression struct {
+	Tunnel string // Content-Encoding sent by the local service, empty when it didn't compress
+	Client string // Content-Encoding sent to the client, empty for none
+
+	mu      sync.RWMutex
+	wire    int64 // body bytes read from the tunnel
+	decoded int64 // body bytes once decoded
+}
+
+// NewCompression starts counting a response body encoded as tunnel and sent to the client as client
+func NewCompression(tunnel, client string) *Compression {
+	return &Compression{Tunnel: tunnel, Client: client}
+}
+
+// Count adds the bytes read from the tunnel and the bytes they decoded to
+func (c *Compression) Count(wire, decoded int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wire += wire
+	c.decoded += decoded
+}
+
+// Sizes returns the bytes read from the tunnel and the bytes they decoded to
+func (c *Compression) Sizes() (wire, decoded int64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.wire, c.decoded
+}
+
+func orIdentity(encoding string) string {
+	if encoding == "" {
+		return "identity"
+	}
+	return encoding
+}
+
+func renderCompression(b *strings.Builder, c *Compression) {
+	wire, decoded := c.Sizes()
+	b.WriteString(sectionStyle.Render("Compression") + "\n")
+	fmt.Fprintf(b, "  Tunnel: %s %s  Client: %s  Body: %s\n",
+		orIdentity(c.Tunnel), HumanBytes(wire), orIdentity(c.Client), HumanBytes(decoded))
+	if c.Tunnel == "" {
+		b.WriteString(hintStyle.Render("  the local service didn't compress the response") + "\n")
+		return
+	}
+	if wire > 0 {
+		fmt.Fprintf(b, "  Ratio: %.1f×, %.0f%% saved on the tunnel\n",
+			float64(decoded)/float64(wire), 100-float64(wire)*100/float64(max(decoded, 1)))
+	}
+}
@@ -2,7 +2,10 @@ package tui
 
 import (
 	"fmt"
+	"net/http"
+	"slices"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/bubbles/table"
@@ -16,6 +19,9 @@ import (
 const (
 	defaultTableHeight = 10
 	maxRequestHistory  = 32
+	// MaxRequestHistory bounds the history size a session can ask for, with the bodies
+	// captured it keeps a session under 30 MB
+	MaxRequestHistory = 200
 )
 
 // Style definitions
@@ -39,6 +45,8 @@ var (
 
 	colPathStyle = lipgloss.NewStyle().Inherit(colPathHeaderStyle)
 
+	colFailureStyle = lipgloss.NewStyle().Inherit(colPathHeaderStyle).Foreground(statusColor(http.StatusBadGateway))
+
 	colUseTimeHeaderStyle = lipgloss.NewStyle().Align(lipgloss.Right)
 
 	colUseTimeStyle = lipgloss.NewStyle().Inherit(colUseTimeHeaderStyle).Foreground(lipgloss.AdaptiveColor{Light: "#4A5568", Dark: "#A0AEC0"})
@@ -126,30 +134,86 @@ type Dashboard struct {
 	tunnelInfo TunnelInfo
 	table      *RequestTable
 	requests   *q.FixedQueue
+	detail     *detailView    // open detail pane, nil when closed
+	editor     *requestEditor // open request editor, nil when closed
+	replay     Replay         // nil when the tunnel can't replay requests
+	export     Export         // nil when the tunnel can't export traffic
+	mu         sync.RWMutex   // guards requests, which are also read by HAR exports
+	notice     noticeMsg      // last notice, shown in the footer
+	filter     *filter        // narrows the table, never nil
+	prompt     *filterPrompt  // open search prompt, nil when closed
+	visible    []*Exchange    // exchanges matching the filter, in table order
+	paused     bool           // table frozen, new exchanges wait in pending
+	pending    []*Exchange    // exchanges received while paused, guarded by mu
+	missed     int            // exchanges received while paused, including those pending dropped
+	stats      stats          // recent traffic figures, kept even while the panel is closed
+	showStats  bool           // stats panel open above the table
+	ticking    bool           // a statsTick is scheduled
+	streaming  bool           // a streamTick is scheduled
+}
+
+// streamTickMsg refreshes the rows of upgraded connections while they are open
+type streamTickMsg struct{}
+
+func streamTick() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return streamTickMsg{}
+	})
+}
+
+// Export publishes a HAR document and returns how it can be downloaded
+type Export func(har []byte) (string, error)
+
+// noticeMsg is a one line message shown in the dashboard footer
+type noticeMsg struct {
+	text  string
+	isErr bool
+}
+
+// trafficMsg carries the bytes counted on the tunnel connections so far
+type trafficMsg struct {
+	recv, sent int64
+}
+
+// poolMsg carries the figures of the pool the tunnel is one of
+type poolMsg struct {
+	size  int
+	share float64
+}
+
+// splitsMsg carries the summaries of the splits the tunnel is a target of
+type splitsMsg struct {
+	lines []string
 }
 
 // TunnelInfo holds information about the tunnel connection
 type TunnelInfo struct {
 	URL       string
+	Aliases   []string // other hosts the tunnel is reachable at
 	ExpiresIn time.Duration
 	BytesRecv int64
 	BytesSent int64
 	ReqCount  int
 	ResCount  int
+	PoolSize  int      // sessions sharing the tunnel name, 0 when it isn't pooled
+	PoolShare float64  // part of the pool's connections this session got
+	Splits    []string // splits sending the tunnel traffic, rendered with their counts
 }
 
 // newDashboard creates a new dashboard instance
-func newDashboard(tunnelAddr string, width, height int, quitFunc func()) *Dashboard {
+func newDashboard(tunnelAddr string, aliases []string, width, height int, quitFunc func()) *Dashboard {
 	return &Dashboard{
 		quitFunc: quitFunc,
 		tunnelInfo: TunnelInfo{
 			URL:       tunnelAddr,
+			Aliases:   aliases,
 			ExpiresIn: 10 * time.Minute,
 		},
 		width:    width,
 		height:   height,
 		table:    newRequestTable(width),
 		requests: q.NewFixedQueue(maxRequestHistory),
+		filter:   &filter{},
 	}
 }
 
@@ -187,18 +251,123 @@ func (d *Dashboard) Init() tea.Cmd {
 func (d *Dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	switch msg := msg.(type) {
+	case *Exchange:
+		d.AddRequest(msg)
+		if nil != msg.Stream && !d.streaming {
+			d.streaming = true
+			return d, streamTick()
+		}
+		return d, nil
+	case streamTickMsg:
+		if d.detail != nil && d.detail.exchange.Stream != nil {
+			d.detail.refresh()
+		}
+		if !d.paused {
+			d.refreshRows()
+		}
+		if d.streamsLive() {
+			return d, streamTick()
+		}
+		d.streaming = false
+		return d, nil
+	case noticeMsg:
+		d.notice = msg
+		return d, nil
+	case trafficMsg:
+		d.tunnelInfo.BytesRecv, d.tunnelInfo.BytesSent = msg.recv, msg.sent
+		return d, nil
+	case poolMsg:
+		d.tunnelInfo.PoolSize, d.tunnelInfo.PoolShare = msg.size, msg.share
+		return d, nil
+	case splitsMsg:
+		d.tunnelInfo.Splits = msg.lines
+		return d, nil
+	case statsTickMsg:
+		if !d.showStats {
+			d.ticking = false
+			return d, nil
+		}
+		d.stats.advance(time.Time(msg))
+		return d, statsTick()
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c":
+		if msg.String() == "ctrl+c" {
 			tea.Quit()
 			d.quitFunc()
 			return d, nil
 		}
+		if d.editor != nil {
+			return d, d.updateEditor(msg)
+		}
+		if d.prompt != nil {
+			return d, d.updatePrompt(msg)
+		}
+		if d.detail == nil {
+			switch msg.String() {
+			case "/":
+				d.prompt = newFilterPrompt(d.filter.String(), d.availableWidth()-4)
+				return d, nil
+			case "m":
+				d.filter.cycleMethod()
+				d.refreshRows()
+				return d, nil
+			case "s":
+				d.filter.cycleClass()
+				d.refreshRows()
+				return d, nil
+			case "l":
+				d.filter.cycleLatency()
+				d.refreshRows()
+				return d, nil
+			case "c":
+				d.setFilter(&filter{})
+				return d, nil
+			}
+		}
+		switch msg.String() {
+		case "p":
+			d.togglePause()
+			return d, nil
+		case "t":
+			return d, d.toggleStats()
+		}
+		switch msg.String() {
+		case "esc":
+			if d.detail != nil {
+				d.detail = nil
+				return d, nil
+			}
+		case "enter":
+			if d.detail == nil {
+				if exchange := d.selected(); exchange != nil {
+					w, h := d.detailSize()
+					d.detail = newDetailView(exchange, w, h)
+				}
+				return d, nil
+			}
+		case "r":
+			d.replaySelected()
+			return d, nil
+		case "e":
+			d.editSelected()
+			return d, nil
+		case "x":
+			d.exportHAR()
+			return d, nil
+		}
+		if d.detail != nil {
+			return d, d.detail.Update(msg)
+		}
 	case tea.WindowSizeMsg:
 		d.width = msg.Width
 		d.height = msg.Height
-		d.table.SetHeight(msg.Height - 8)
+		d.table.SetHeight(d.tableHeight())
 		d.updateTableWidth()
+		if d.detail != nil {
+			d.detail.setSize(d.detailSize())
+		}
+		if d.editor != nil {
+			d.editor.setSize(d.detailSize())
+		}
 	}
 
 	// Always update the table model
@@ -208,6 +377,201 @@ func (d *Dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return d, cmd
 }
 
+// exchanges returns a snapshot of the kept exchanges, oldest first
+func (d *Dashboard) exchanges() []*Exchange {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	items := d.requests.Items()
+	exchanges := make([]*Exchange, len(items), len(items)+len(d.pending))
+	for i, item := range items {
+		exchanges[i] = item.(*Exchange)
+	}
+	return append(exchanges, d.pending...)
+}
+
+// streamsLive reports whether a kept exchange still has an open stream
+func (d *Dashboard) streamsLive() bool {
+	for _, e := range d.exchanges() {
+		if nil != e.Stream && e.Stream.Live() {
+			return true
+		}
+	}
+	return false
+}
+
+// tableHeight returns the rows left for the table below the header, and the stats panel when open
+func (d *Dashboard) tableHeight() int {
+	height := d.height - 9 - d.aliasLines()
+	if d.showStats {
+		height -= statsPanelHeight
+	}
+	return max(height, 3)
+}
+
+// toggleStats opens or closes the stats panel, the returned command keeps its sparkline moving
+func (d *Dashboard) toggleStats() tea.Cmd {
+	d.showStats = !d.showStats
+	d.table.SetHeight(d.tableHeight())
+	if !d.showStats || d.ticking {
+		return nil
+	}
+	d.ticking = true
+	d.stats.advance(time.Now())
+	return statsTick()
+}
+
+// togglePause freezes the table, or merges the exchanges received meanwhile when resuming
+func (d *Dashboard) togglePause() {
+	d.paused = !d.paused
+	if d.paused {
+		return
+	}
+	d.mu.Lock()
+	for _, e := range d.pending {
+		d.requests.Push(e)
+	}
+	d.pending = nil
+	d.mu.Unlock()
+	d.missed = 0
+	d.refreshRows()
+}
+
+// exportHAR publishes the kept exchanges and shows how to download them
+func (d *Dashboard) exportHAR() {
+	if d.export == nil {
+		d.notice = noticeMsg{text: "export is not available on this tunnel", isErr: true}
+		return
+	}
+	data, err := marshalHAR(d.exchanges())
+	if err == nil {
+		var download string
+		if download, err = d.export(data); err == nil {
+			d.notice = noticeMsg{text: "HAR ready: " + download}
+			return
+		}
+	}
+	d.notice = noticeMsg{text: "can't export: " + err.Error(), isErr: true}
+}
+
+// selected returns the exchange under the table cursor
+func (d *Dashboard) selected() *Exchange {
+	cursor := d.table.Cursor()
+	if cursor < 0 || cursor >= len(d.visible) {
+		return nil
+	}
+	return d.visible[cursor]
+}
+
+// updatePrompt handles keys while the search prompt is open, the table follows the query as it is typed
+func (d *Dashboard) updatePrompt(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		if f, err := parseFilter(d.prompt.previous); err == nil {
+			d.setFilter(f)
+		}
+		d.prompt = nil
+		return nil
+	case "enter":
+		if d.prompt.filter() != nil {
+			d.prompt = nil
+		}
+		return nil
+	}
+	cmd := d.prompt.Update(msg)
+	if f := d.prompt.filter(); f != nil {
+		d.setFilter(f)
+	}
+	return cmd
+}
+
+func (d *Dashboard) setFilter(f *filter) {
+	d.filter = f
+	d.refreshRows()
+}
+
+// updateEditor handles keys while the request editor is open
+func (d *Dashboard) updateEditor(msg tea.KeyMsg) tea.Cmd {
+	switch msg.String() {
+	case "esc":
+		d.editor = nil
+		return nil
+	case "ctrl+s":
+		if req := d.editor.request(); req != nil {
+			d.editor = nil
+			d.send(req)
+		}
+		return nil
+	}
+	return d.editor.Update(msg)
+}
+
+// replaySelected sends the selected request through the tunnel again
+func (d *Dashboard) replaySelected() {
+	exchange := d.selected()
+	if exchange == nil {
+		return
+	}
+	req, err := replayRequest(exchange)
+	if err != nil {
+		d.notice = noticeMsg{text: "can't replay: " + err.Error(), isErr: true}
+		return
+	}
+	d.send(req)
+}
+
+// editSelected opens the request editor on the selected request
+func (d *Dashboard) editSelected() {
+	exchange := d.selected()
+	if exchange == nil {
+		return
+	}
+	text, err := dumpRequest(exchange)
+	if err != nil {
+		d.notice = noticeMsg{text: "can't edit: " + err.Error(), isErr: true}
+		return
+	}
+	w, h := d.detailSize()
+	d.detail = nil
+	d.editor = newRequestEditor(text, w, h)
+}
+
+func (d *Dashboard) send(req *http.Request) {
+	if d.replay == nil {
+		d.notice = noticeMsg{text: "replay is not available on this tunnel", isErr: true}
+		return
+	}
+	d.replay(req)
+	d.notice = noticeMsg{text: fmt.Sprintf("replaying %s %s", req.Method, req.URL.RequestURI())}
+}
+
+// renderFooter renders key hints followed by the last notice
+func (d *Dashboard) renderFooter(hints string) string {
+	footer := hintStyle.Render(hints)
+	if d.notice.text == "" {
+		return footer
+	}
+	style := noticeStyle
+	if d.notice.isErr {
+		style = errorStyle
+	}
+	return lipgloss.JoinHorizontal(lipgloss.Left, footer, "  ", style.Render(d.notice.text))
+}
+
+// detailSize returns the viewport size of the detail pane, leaving room for its border and footer
+func (d *Dashboard) detailSize() (int, int) {
+	return max(d.availableWidth()-4, 10), max(d.height-11-d.aliasLines(), 3)
+}
+
+// aliasLines is how many lines the header takes for the other urls and the splits of the
+// tunnel, or the pool line
+func (d *Dashboard) aliasLines() int {
+	lines := len(d.tunnelInfo.Aliases) + len(d.tunnelInfo.Splits)
+	if d.tunnelInfo.PoolSize > 0 {
+		return max(lines, 1)
+	}
+	return lines
+}
+
 // renderHeader renders the header section with URLs and stats
 func (d *Dashboard) renderHeader() string {
 	// URLs section
@@ -216,24 +580,45 @@ func (d *Dashboard) renderHeader() string {
 		lipgloss.NewStyle().Inherit(urlStyle).Width(d.width/2).Render(fmt.Sprintf("HTTP:  http://%s", d.tunnelInfo.URL)),
 		lipgloss.NewStyle().Inherit(urlStyle).Width(d.width/2).Render(fmt.Sprintf("HTTPS: https://%s", d.tunnelInfo.URL)),
 	)
+	for _, alias := range d.tunnelInfo.Aliases {
+		leftURLS = lipgloss.JoinVertical(lipgloss.Left, leftURLS,
+			lipgloss.NewStyle().Inherit(urlStyle).Width(d.width/2).Render(fmt.Sprintf("ALSO:  https://%s", alias)))
+	}
+	for _, split := range d.tunnelInfo.Splits {
+		leftURLS = lipgloss.JoinVertical(lipgloss.Left, leftURLS,
+			lipgloss.NewStyle().Inherit(urlStyle).Width(d.width/2).Render("SPLIT: "+split))
+	}
 
 	// Stats section
 	leftStats := lipgloss.JoinVertical(
 		lipgloss.Left,
-		lipgloss.NewStyle().Inherit(statsStyle).Width(d.width/4).Render(fmt.Sprintf("↓ %s", humanBytes(d.tunnelInfo.BytesRecv))),
-		lipgloss.NewStyle().Inherit(statsStyle).Width(d.width/4).Render(fmt.Sprintf("↑ %s", humanBytes(d.tunnelInfo.BytesSent))),
+		lipgloss.NewStyle().Inherit(statsStyle).Width(d.width/4).Render(fmt.Sprintf("↓ %s", HumanBytes(d.tunnelInfo.BytesRecv))),
+		lipgloss.NewStyle().Inherit(statsStyle).Width(d.width/4).Render(fmt.Sprintf("↑ %s", HumanBytes(d.tunnelInfo.BytesSent))),
 	)
+	if d.tunnelInfo.PoolSize > 0 {
+		leftStats = lipgloss.JoinVertical(lipgloss.Left, leftStats,
+			lipgloss.NewStyle().Inherit(statsStyle).Width(d.width/4).Render(
+				fmt.Sprintf("Pool: %d · %.0f%%", d.tunnelInfo.PoolSize, d.tunnelInfo.PoolShare*100)))
+	}
 
 	srCount := lipgloss.JoinVertical(
 		lipgloss.Left,
 		lipgloss.NewStyle().Inherit(statsStyle).Width(d.width/4).Align(lipgloss.Center).Render(fmt.Sprintf("ReqCount: %d", d.tunnelInfo.ReqCount)),
-		lipgloss.NewStyle().Inherit(statsStyle).Width(d.width/4).Align(lipgloss.Center).Render(fmt.Sprintf("ResCount: %d", d.tunnelInfo.ResCount)),
+		lipgloss.NewStyle().Inherit(statsStyle).Width(d.width/4).Align(lipgloss.Center).Render(d.renderCount()),
 	)
 
 	rightStats := lipgloss.JoinHorizontal(lipgloss.Top, leftStats, srCount)
 	return lipgloss.JoinHorizontal(lipgloss.Top, leftURLS, rightStats)
 }
 
+// renderCount renders the response count, or how many of the kept requests match the filter
+func (d *Dashboard) renderCount() string {
+	if d.filter.active() {
+		return fmt.Sprintf("Shown: %d/%d", len(d.visible), d.requests.Len())
+	}
+	return fmt.Sprintf("ResCount: %d", d.tunnelInfo.ResCount)
+}
+
 // renderProjectInfo renders the project information section
 func (d *Dashboard) renderProjectInfo() string {
 	return lipgloss.JoinVertical(
@@ -254,7 +639,14 @@ func (d *Dashboard) View() string {
 	head := d.renderHeader()
 
 	var content string
-	if d.requests.Len() == 0 {
+	if d.showStats && d.editor == nil && d.detail == nil {
+		head = lipgloss.JoinVertical(lipgloss.Left, headerStyle.Render(head), d.stats.view(d.availableWidth()))
+	} else {
+		head = headerStyle.Render(head)
+	}
+	if d.requests.Len() == 0 && !d.paused && d.showStats {
+		content = d.renderFooter("waiting for requests • t close stats")
+	} else if d.requests.Len() == 0 && !d.paused {
 		// Show QR code and project info when table is empty
 		qrCode := generateQRCode(d.tunnelInfo.URL)
 		content = lipgloss.JoinHorizontal(
@@ -262,39 +654,46 @@ func (d *Dashboard) View() string {
 			lipgloss.NewStyle().PaddingRight(4).Render(d.renderProjectInfo()),
 			qrStyle.Render(qrCode),
 		)
+	} else if d.editor != nil {
+		content = d.editor.View()
+	} else if d.detail != nil {
+		content = lipgloss.JoinVertical(lipgloss.Left, d.detail.View(), d.renderFooter("r replay • e edit & replay"))
+	} else if d.prompt != nil {
+		content = lipgloss.JoinVertical(lipgloss.Left, d.table.View(), d.prompt.View())
 	} else {
-		content = d.table.View()
+		hints := "enter details • r replay • e edit & replay • x export HAR • / search • m s l filter • p pause • t stats • ctrl+c quit"
+		if d.filter.active() {
+			hints = "filter: " + d.filter.String() + " • c clear • " + hints
+		}
+		if d.paused {
+			hints = fmt.Sprintf("⏸ paused, %d new requests • p resume • ", d.missed) + hints
+		}
+		content = lipgloss.JoinVertical(lipgloss.Left, d.table.View(), d.renderFooter(hints))
 	}
 
 	return dashStyle.Render(
 		lipgloss.JoinVertical(lipgloss.Left,
-			headerStyle.Render(head),
+			head,
 			content),
 	)
 }
 
-func getBytes(s string) int64 {
-	atoi, err := strconv.ParseInt(s, 10, 64)
-	if nil != err {
-		return 0
-	}
-	return atoi
+func renderStatusCode(status int) string {
+	return lipgloss.NewStyle().Inherit(colStatusStyle).Foreground(statusColor(status)).Render(strconv.Itoa(status))
 }
 
-func renderStatusCode(status int) string {
-	s := strconv.Itoa(status)
-	style := lipgloss.NewStyle().Inherit(colStatusStyle)
+func statusColor(status int) lipgloss.AdaptiveColor {
 	switch {
 	case status >= 500:
-		return style.Foreground(lipgloss.AdaptiveColor{Light: "#E53E3E", Dark: "#FC8181"}).Render(s) // Bright Red
+		return lipgloss.AdaptiveColor{Light: "#E53E3E", Dark: "#FC8181"} // Bright Red
 	case status >= 400:
-		return style.Foreground(lipgloss.AdaptiveColor{Light: "#DD6B20", Dark: "#FBD38D"}).Render(s) // Bright Orange
+		return lipgloss.AdaptiveColor{Light: "#DD6B20", Dark: "#FBD38D"} // Bright Orange
 	case status >= 300:
-		return style.Foreground(lipgloss.AdaptiveColor{Light: "#3182CE", Dark: "#90CDF4"}).Render(s) // Bright Blue
+		return lipgloss.AdaptiveColor{Light: "#3182CE", Dark: "#90CDF4"} // Bright Blue
 	case status >= 200:
-		return style.Foreground(lipgloss.AdaptiveColor{Light: "#38A169", Dark: "#9AE6B4"}).Render(s) // Bright Green
+		return lipgloss.AdaptiveColor{Light: "#38A169", Dark: "#9AE6B4"} // Bright Green
 	default:
-		return style.Foreground(lipgloss.AdaptiveColor{Light: "#718096", Dark: "#A0AEC0"}).Render(s) // Cool Gray
+		return lipgloss.AdaptiveColor{Light: "#718096", Dark: "#A0AEC0"} // Cool Gray
 	}
 }
 
@@ -317,35 +716,72 @@ func renderMethod(method string) string {
 }
 
 // AddRequest adds a new request to the dashboard
-func (d *Dashboard) AddRequest(req *httpExchange) {
-
-	reqByte := getBytes(req.Request.Header.Get("Content-Length"))
-	respByte := getBytes(req.Response.Header.Get("Content-Length"))
-
-	d.tunnelInfo.BytesRecv += reqByte
-	d.tunnelInfo.BytesSent += respByte
-
+func (d *Dashboard) AddRequest(req *Exchange) {
 	d.tunnelInfo.ReqCount += 1
 	d.tunnelInfo.ResCount += 1
-
+	req.seq = d.tunnelInfo.ReqCount
+	d.stats.add(req, time.Now())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.paused {
+		d.missed++
+		// older pending exchanges would be pushed out of the history on resume anyway
+		if len(d.pending) == d.requests.Cap() {
+			d.pending = d.pending[1:]
+		}
+		d.pending = append(d.pending, req)
+		return
+	}
 	d.requests.Push(req)
 
-	rows := make([]table.Row, d.requests.Len())
-	// Update table rows
-	for i, item := range d.requests.Items() {
-		r := item.(*httpExchange)
-		path := colPathStyle.Render(r.RequestURI)
-		t := colUseTimeStyle.Render(humanMillis(r.useTime))
+	d.refreshRows()
+}
+
+// refreshRows rebuilds the table from the kept exchanges matching the filter
+func (d *Dashboard) refreshRows() {
+	// keep the cursor on the same exchange as rows come, go or get filtered out
+	selected := d.selected()
+	rows := make([]table.Row, 0, d.requests.Len())
+	d.visible = d.visible[:0]
+	for _, item := range d.requests.Items() {
+		r := item.(*Exchange)
+		if !d.filter.match(r) {
+			continue
+		}
+		d.visible = append(d.visible, r)
+		path := r.URL.RequestURI()
+		if nil != r.Stream {
+			path += "  " + r.Stream.summary()
+		}
+		if r.Edge != "" {
+			path += "  ⇤ " + r.Edge + " at the edge"
+		}
+		if r.Failure != "" {
+			path = colFailureStyle.Render(path + "  ✗ " + r.Failure)
+		} else {
+			path = colPathStyle.Render(path)
+		}
+		t := colUseTimeStyle.Render(humanMillis(r.UseTime))
 
-		rows[i] = table.Row{
-			colNoStyle.Render(strconv.Itoa(i + 1)),
+		no := strconv.Itoa(r.seq)
+		if r.Replay {
+			no += "↻"
+		}
+		rows = append(rows, table.Row{
+			colNoStyle.Render(no),
 			renderMethod(r.Method),
 			renderStatusCode(r.StatusCode),
 			path,
 			t,
-		}
+		})
 	}
 	d.table.SetRows(rows)
+	if i := slices.Index(d.visible, selected); i >= 0 {
+		d.table.SetCursor(i)
+	} else if cursor := d.table.Cursor(); cursor < 0 || cursor >= len(rows) {
+		d.table.SetCursor(max(len(rows)-1, 0))
+	}
 }
 
 // UpdateStats updates the tunnel information statistics
@@ -0,0 +1,96 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func key(s string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+func TestDashboardPause(t *testing.T) {
+	d := newDashboard("a.webs.sh", nil, 120, 40, func() {})
+	d.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	(&Tui{dashboard: d}).SetHistory(3)
+
+	first := filterExchange("GET", "/1", 200, 1)
+	second := filterExchange("GET", "/2", 200, 1)
+	d.AddRequest(first)
+	d.AddRequest(second)
+	d.table.SetCursor(0)
+
+	d.Update(key("p"))
+	for i := 0; i < 5; i++ {
+		d.AddRequest(filterExchange("POST", "/new", 201, 1))
+	}
+	if len(d.visible) != 2 || d.selected() != first {
+		t.Fatalf("paused table changed: %d rows, selected %v", len(d.visible), d.selected())
+	}
+	if d.missed != 5 || len(d.pending) != 3 {
+		t.Fatalf("missed = %d, pending = %d", d.missed, len(d.pending))
+	}
+	if got := len(d.exchanges()); got != 5 {
+		t.Errorf("exchanges() = %d, want kept and pending", got)
+	}
+
+	d.table.SetCursor(1)
+	d.Update(key("p"))
+	if d.paused || len(d.pending) != 0 || len(d.visible) != 3 {
+		t.Fatalf("resume: paused %v, %d pending, %d rows", d.paused, len(d.pending), len(d.visible))
+	}
+	// the second exchange was pushed out of the history by the merge
+	if d.selected() == second {
+		t.Errorf("selected an evicted exchange")
+	}
+
+	d.AddRequest(filterExchange("GET", "/8", 200, 1))
+	d.table.SetCursor(1)
+	selected := d.selected()
+	d.AddRequest(filterExchange("GET", "/9", 200, 1))
+	if d.selected() != selected {
+		t.Errorf("selection moved from #%d to #%d", selected.seq, d.selected().seq)
+	}
+}
+
+func TestDashboardPool(t *testing.T) {
+	d := newDashboard("a.webs.sh", nil, 120, 40, func() {})
+	d.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	if strings.Contains(d.renderHeader(), "Pool") || d.aliasLines() != 0 {
+		t.Fatal("a tunnel that isn't pooled shows a pool")
+	}
+	d.Update(poolMsg{size: 3, share: 0.25})
+	if !strings.Contains(d.renderHeader(), "Pool: 3 · 25%") {
+		t.Errorf("header doesn't show the pool:\n%s", d.renderHeader())
+	}
+	if d.aliasLines() != 1 {
+		t.Errorf("aliasLines() = %d, the pool line takes one", d.aliasLines())
+	}
+}
+
+func TestDashboardEdge(t *testing.T) {
+	d := newDashboard("a.webs.sh", nil, 120, 40, func() {})
+	d.Update(tea.WindowSizeMsg{Width: 120, Height: 40})
+	e := filterExchange("OPTIONS", "/api", 204, 0)
+	e.Edge = "CORS preflight"
+	d.AddRequest(e)
+	if row := d.table.Rows()[0]; !strings.Contains(row[3], "CORS preflight at the edge") {
+		t.Errorf("row = %q, want the preflight marked as answered at the edge", row)
+	}
+	if !strings.Contains(renderExchange(e, 120), "answered at the edge") {
+		t.Errorf("the details don't mark the preflight as answered at the edge")
+	}
+}
+
+func TestRenderCompression(t *testing.T) {
+	e := filterExchange("GET", "/app.js", 200, 3)
+	e.Compression = NewCompression("br", "gzip")
+	e.Compression.Count(1000, 0)
+	e.Compression.Count(0, 4000)
+	out := renderExchange(e, 120)
+	if !strings.Contains(out, "Tunnel: br") || !strings.Contains(out, "Client: gzip") || !strings.Contains(out, "4.0×, 75% saved") {
+		t.Errorf("details don't show the compression:\n%s", out)
+	}
+}
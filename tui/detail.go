@@ -0,0 +1,149 @@
+package tui
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Style definitions for the detail pane
+var (
+	detailStyle = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).BorderForeground(lipgloss.AdaptiveColor{Light: "#CBD5E0", Dark: "#4A5568"}).PaddingLeft(1).PaddingRight(1)
+
+	sectionStyle = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.AdaptiveColor{Light: "#3182CE", Dark: "#90CDF4"}).PaddingTop(1)
+
+	headerNameStyle = lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#4A5568", Dark: "#A0AEC0"})
+
+	hintStyle = lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#718096", Dark: "#718096"})
+
+	noticeStyle = lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#38A169", Dark: "#9AE6B4"})
+
+	errorStyle = lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#E53E3E", Dark: "#FC8181"})
+)
+
+// detailView shows a single exchange in a scrollable pane
+type detailView struct {
+	viewport viewport.Model
+	exchange *Exchange
+}
+
+func newDetailView(exchange *Exchange, width, height int) *detailView {
+	d := &detailView{
+		viewport: viewport.New(width, height),
+		exchange: exchange,
+	}
+	d.viewport.SetContent(renderExchange(exchange, width))
+	return d
+}
+
+func (d *detailView) setSize(width, height int) {
+	d.viewport.Width = width
+	d.viewport.Height = height
+	d.viewport.SetContent(renderExchange(d.exchange, width))
+}
+
+// refresh renders the exchange again, keeping the scroll position, for streams that are still moving
+func (d *detailView) refresh() {
+	d.viewport.SetContent(renderExchange(d.exchange, d.viewport.Width))
+}
+
+func (d *detailView) Update(msg tea.Msg) tea.Cmd {
+	var cmd tea.Cmd
+	d.viewport, cmd = d.viewport.Update(msg)
+	return cmd
+}
+
+func (d *detailView) View() string {
+	footer := hintStyle.Render(fmt.Sprintf("↑/↓ scroll • esc close • %3.f%%", d.viewport.ScrollPercent()*100))
+	return lipgloss.JoinVertical(lipgloss.Left, detailStyle.Render(d.viewport.View()), footer)
+}
+
+// sortedKeys returns the keys of a header or query map in order
+func sortedKeys[M ~map[string][]string](m M) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// renderHeaders renders headers sorted by name, one per line
+func renderHeaders(b *strings.Builder, header http.Header) {
+	if len(header) == 0 {
+		b.WriteString("  (none)\n")
+		return
+	}
+	for _, name := range sortedKeys(header) {
+		for _, v := range header[name] {
+			fmt.Fprintf(b, "  %s %s\n", headerNameStyle.Render(name+":"), v)
+		}
+	}
+}
+
+func renderQuery(b *strings.Builder, query url.Values) {
+	for _, k := range sortedKeys(query) {
+		for _, v := range query[k] {
+			fmt.Fprintf(b, "  %s %s\n", headerNameStyle.Render(k+" ="), v)
+		}
+	}
+}
+
+// renderSize formats the size of a message body from its Content-Length
+func renderSize(contentLength int64) string {
+	if contentLength < 0 {
+		return "unknown"
+	}
+	return orStr(humanSize(fmt.Sprint(contentLength)), "-")
+}
+
+// renderExchange renders the full request and response of an exchange
+func renderExchange(e *Exchange, width int) string {
+	b := &strings.Builder{}
+	req, resp := e.Request, e.Response
+
+	fmt.Fprintf(b, "%s %s %s  →  %s  %s\n",
+		renderMethod(req.Method), req.URL.RequestURI(), req.Proto,
+		renderStatusCode(resp.StatusCode), humanMillis(e.UseTime))
+	if e.Replay {
+		b.WriteString(hintStyle.Render("  ↻ replayed from the dashboard") + "\n")
+	}
+	if e.Edge != "" {
+		b.WriteString(hintStyle.Render("  ⇤ "+e.Edge+" answered at the edge, the tunnel never saw it") + "\n")
+	}
+	if e.Failure != "" {
+		b.WriteString(colFailureStyle.Render("  ✗ "+e.Failure+", the edge answered instead") + "\n")
+	}
+
+	b.WriteString(sectionStyle.Render("Request") + "\n")
+	fmt.Fprintf(b, "  Host: %s  Type: %s  Size: %s\n",
+		req.Host, parseContentType(req.Header.Get("Content-Type")), renderSize(req.ContentLength))
+	if query := req.URL.Query(); len(query) > 0 {
+		b.WriteString(sectionStyle.Render("Query Parameters") + "\n")
+		renderQuery(b, query)
+	}
+	b.WriteString(sectionStyle.Render("Request Headers") + "\n")
+	renderHeaders(b, req.Header)
+	renderBody(b, "Request Body", e.RequestBody, req.TransferEncoding, req.Header)
+
+	b.WriteString(sectionStyle.Render("Response") + "\n")
+	fmt.Fprintf(b, "  Status: %s  Type: %s  Size: %s\n",
+		resp.Status, parseContentType(resp.Header.Get("Content-Type")), renderSize(resp.ContentLength))
+	b.WriteString(sectionStyle.Render("Response Headers") + "\n")
+	renderHeaders(b, resp.Header)
+	renderBody(b, "Response Body", e.ResponseBody, resp.TransferEncoding, resp.Header)
+	if nil != e.Compression {
+		renderCompression(b, e.Compression)
+	}
+	if nil != e.Stream {
+		renderStream(b, e.Stream)
+	}
+
+	return lipgloss.NewStyle().Width(width).Render(b.String())
+}
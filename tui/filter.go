@@ -0,0 +1,182 @@
+package tui
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// filter narrows the request table, its categories are combined with AND and
+// the values within one category with OR. It is written as space separated tokens:
+//
+//	GET POST    methods
+//	4xx 5xx     status classes
+//	>250ms      minimum latency
+//	~^/api/v\d  path regular expression
+//	/users      anything else is a path substring
+type filter struct {
+	methods    []string
+	classes    []int // status code divided by 100
+	minLatency time.Duration
+	paths      []string
+	pathRe     *regexp.Regexp
+}
+
+var (
+	filterMethods = []string{"", http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch}
+	filterClasses = []int{0, 2, 3, 4, 5}
+	filterLatency = []time.Duration{0, 100 * time.Millisecond, 500 * time.Millisecond, time.Second}
+
+	statusClassRe = regexp.MustCompile(`^[1-5]xx$`)
+	knownMethods  = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace}
+)
+
+// parseFilter parses a filter query, an empty query matches everything
+func parseFilter(query string) (*filter, error) {
+	f := &filter{}
+	for _, token := range strings.Fields(query) {
+		switch {
+		case slices.Contains(knownMethods, strings.ToUpper(token)):
+			f.methods = append(f.methods, strings.ToUpper(token))
+		case statusClassRe.MatchString(strings.ToLower(token)):
+			f.classes = append(f.classes, int(token[0]-'0'))
+		case strings.HasPrefix(token, ">"):
+			d, err := time.ParseDuration(token[1:])
+			if err != nil {
+				return nil, fmt.Errorf("latency %q: use a duration like >250ms", token[1:])
+			}
+			f.minLatency = d
+		case strings.HasPrefix(token, "~"):
+			re, err := regexp.Compile(token[1:])
+			if err != nil {
+				return nil, fmt.Errorf("path regexp: %w", err)
+			}
+			f.pathRe = re
+		default:
+			f.paths = append(f.paths, token)
+		}
+	}
+	return f, nil
+}
+
+// active reports whether the filter hides anything
+func (f *filter) active() bool {
+	return len(f.methods) > 0 || len(f.classes) > 0 || f.minLatency > 0 || len(f.paths) > 0 || f.pathRe != nil
+}
+
+func (f *filter) match(e *Exchange) bool {
+	if len(f.methods) > 0 && !slices.Contains(f.methods, e.Request.Method) {
+		return false
+	}
+	if len(f.classes) > 0 && !slices.Contains(f.classes, e.Response.StatusCode/100) {
+		return false
+	}
+	if f.minLatency > 0 && time.Duration(e.UseTime)*time.Millisecond < f.minLatency {
+		return false
+	}
+	path := e.Request.URL.RequestURI()
+	if len(f.paths) > 0 && !slices.ContainsFunc(f.paths, func(p string) bool { return strings.Contains(path, p) }) {
+		return false
+	}
+	return f.pathRe == nil || f.pathRe.MatchString(path)
+}
+
+// String renders the filter back as a query
+func (f *filter) String() string {
+	var tokens []string
+	tokens = append(tokens, f.methods...)
+	for _, c := range f.classes {
+		tokens = append(tokens, fmt.Sprintf("%dxx", c))
+	}
+	if f.minLatency > 0 {
+		tokens = append(tokens, ">"+f.minLatency.String())
+	}
+	tokens = append(tokens, f.paths...)
+	if f.pathRe != nil {
+		tokens = append(tokens, "~"+f.pathRe.String())
+	}
+	return strings.Join(tokens, " ")
+}
+
+// cycle returns the quick filter value following current, the zero value of values clears the filter
+func cycle[T comparable](values []T, current []T) []T {
+	var zero, cur T
+	if len(current) == 1 {
+		cur = current[0]
+	}
+	n := values[(slices.Index(values, cur)+1)%len(values)]
+	if n == zero {
+		return nil
+	}
+	return []T{n}
+}
+
+// cycleMethod steps the method quick filter through the common methods
+func (f *filter) cycleMethod() {
+	f.methods = cycle(filterMethods, f.methods)
+}
+
+// cycleClass steps the status quick filter through the status classes
+func (f *filter) cycleClass() {
+	f.classes = cycle(filterClasses, f.classes)
+}
+
+// cycleLatency steps the minimum latency quick filter through a few thresholds
+func (f *filter) cycleLatency() {
+	l := cycle(filterLatency, []time.Duration{f.minLatency})
+	f.minLatency = 0
+	if l != nil {
+		f.minLatency = l[0]
+	}
+}
+
+// filterPrompt is the `/` search prompt, the table is filtered as the query is typed
+type filterPrompt struct {
+	input    textinput.Model
+	previous string // query to restore when the prompt is cancelled
+	err      string
+}
+
+func newFilterPrompt(query string, width int) *filterPrompt {
+	ti := textinput.New()
+	ti.Prompt = "/"
+	ti.Placeholder = "GET 4xx >250ms /api ~^/v\\d"
+	ti.Width = width
+	ti.SetValue(query)
+	ti.CursorEnd()
+	ti.Focus()
+	return &filterPrompt{input: ti, previous: query}
+}
+
+func (p *filterPrompt) Update(msg tea.Msg) tea.Cmd {
+	var cmd tea.Cmd
+	p.input, cmd = p.input.Update(msg)
+	return cmd
+}
+
+// filter parses the typed query, keeping the error for display when it is invalid
+func (p *filterPrompt) filter() *filter {
+	f, err := parseFilter(p.input.Value())
+	if err != nil {
+		p.err = err.Error()
+		return nil
+	}
+	p.err = ""
+	return f
+}
+
+func (p *filterPrompt) View() string {
+	view := p.input.View()
+	if p.err != "" {
+		view = lipgloss.JoinHorizontal(lipgloss.Left, view, "  ", errorStyle.Render(p.err))
+	}
+	return view
+}
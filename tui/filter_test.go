@@ -0,0 +1,89 @@
+package tui
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func filterExchange(method, uri string, status int, useTime int64) *Exchange {
+	u, _ := url.ParseRequestURI(uri)
+	return &Exchange{
+		Request:  &http.Request{Method: method, URL: u},
+		Response: &http.Response{StatusCode: status},
+		UseTime:  useTime,
+	}
+}
+
+func TestFilter(t *testing.T) {
+	exchanges := []*Exchange{
+		filterExchange("GET", "/api/v1/users", 200, 12),
+		filterExchange("POST", "/api/v2/orders?id=3", 201, 640),
+		filterExchange("GET", "/static/app.js", 404, 3),
+		filterExchange("DELETE", "/api/v1/users/7", 500, 1200),
+	}
+	tests := []struct {
+		query   string
+		want    []int
+		wantErr bool
+	}{
+		{query: "", want: []int{0, 1, 2, 3}},
+		{query: "get", want: []int{0, 2}},
+		{query: "GET DELETE", want: []int{0, 2, 3}},
+		{query: "2xx", want: []int{0, 1}},
+		{query: "4xx 5xx", want: []int{2, 3}},
+		{query: ">500ms", want: []int{1, 3}},
+		{query: "users", want: []int{0, 3}},
+		{query: "id=3", want: []int{1}},
+		{query: `~^/api/v\d/users$`, want: []int{0}},
+		{query: "GET /api 2xx", want: []int{0}},
+		{query: ">fast", wantErr: true},
+		{query: "~(", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			f, err := parseFilter(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			var got []int
+			for i, e := range exchanges {
+				if f.match(e) {
+					got = append(got, i)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("matched %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("matched %v, want %v", got, tt.want)
+				}
+			}
+			if again, _ := parseFilter(f.String()); again.String() != f.String() {
+				t.Errorf("String() = %q doesn't round trip", f.String())
+			}
+		})
+	}
+}
+
+func TestQuickFilters(t *testing.T) {
+	f := &filter{}
+	var methods []string
+	for range filterMethods {
+		f.cycleMethod()
+		methods = append(methods, f.String())
+	}
+	if methods[0] != "GET" || methods[len(methods)-1] != "" {
+		t.Errorf("method cycle = %q", methods)
+	}
+	f.cycleClass()
+	f.cycleClass()
+	f.cycleLatency()
+	if got := f.String(); got != "3xx >100ms" {
+		t.Errorf("String() = %q, want %q", got, "3xx >100ms")
+	}
+}
@@ -0,0 +1,249 @@
+package tui
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// HAR 1.2 document, see http://www.softwareishard.com/blog/har-12-spec/
+type HAR struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            int64       `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int64          `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size        int64  `json:"size"`
+	Compression int64  `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Blocked int64 `json:"blocked"`
+	DNS     int64 `json:"dns"`
+	Connect int64 `json:"connect"`
+	Send    int64 `json:"send"`
+	Wait    int64 `json:"wait"`
+	Receive int64 `json:"receive"`
+	SSL     int64 `json:"ssl"`
+}
+
+func harVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+func harHeaders(header http.Header) []harNameValue {
+	values := make([]harNameValue, 0, len(header))
+	for _, name := range sortedKeys(header) {
+		for _, v := range header[name] {
+			values = append(values, harNameValue{Name: name, Value: v})
+		}
+	}
+	return values
+}
+
+func harCookies(cookies []*http.Cookie) []harNameValue {
+	values := make([]harNameValue, 0, len(cookies))
+	for _, c := range cookies {
+		values = append(values, harNameValue{Name: c.Name, Value: c.Value})
+	}
+	return values
+}
+
+// harURL rebuilds the absolute url the client requested
+func harURL(req *http.Request) string {
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + req.URL.RequestURI()
+}
+
+// bodySize returns the number of body bytes seen on the wire, -1 when unknown
+func bodySize(e *Exchange, isRequest bool) int64 {
+	body, contentLength := e.ResponseBody, e.Response.ContentLength
+	if isRequest {
+		body, contentLength = e.RequestBody, e.Request.ContentLength
+	}
+	if body != nil {
+		return body.Total()
+	}
+	return contentLength
+}
+
+func harRequestOf(e *Exchange) harRequest {
+	req := e.Request
+	r := harRequest{
+		Method:      req.Method,
+		URL:         harURL(req),
+		HTTPVersion: req.Proto,
+		Cookies:     harCookies(req.Cookies()),
+		Headers:     harHeaders(req.Header),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    bodySize(e, true),
+	}
+	for _, k := range sortedKeys(req.URL.Query()) {
+		for _, v := range req.URL.Query()[k] {
+			r.QueryString = append(r.QueryString, harNameValue{Name: k, Value: v})
+		}
+	}
+	if e.RequestBody != nil && e.RequestBody.Total() > 0 {
+		data, _ := decodeBody(e.RequestBody.Bytes(), req.TransferEncoding, req.Header)
+		r.PostData = &harPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     string(data),
+		}
+	}
+	return r
+}
+
+func harResponseOf(e *Exchange) harResponse {
+	resp := e.Response
+	mimeType := resp.Header.Get("Content-Type")
+	r := harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Cookies:     harCookies(resp.Cookies()),
+		Headers:     harHeaders(resp.Header),
+		Content:     harContent{Size: -1, MimeType: mimeType},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    bodySize(e, false),
+	}
+	if e.ResponseBody == nil {
+		r.Content.Comment = "body capture disabled"
+		return r
+	}
+
+	// the entity without the chunk framing is what the content encoding was applied to
+	entity := dechunk(e.ResponseBody.Bytes(), resp.TransferEncoding)
+	data, err := decodeContent(entity, resp.Header.Get("Content-Encoding"))
+	r.Content.Size = int64(len(data))
+	if !e.ResponseBody.Truncated() && err == nil {
+		r.Content.Compression = r.Content.Size - int64(len(entity))
+	}
+	mediatype, _, _ := mime.ParseMediaType(mimeType)
+	if isBinary(data) || (len(data) > 0 && mediatype != "" && !isTextual(mediatype)) {
+		r.Content.Text = base64.StdEncoding.EncodeToString(data)
+		r.Content.Encoding = "base64"
+	} else {
+		r.Content.Text = string(data)
+	}
+	if e.ResponseBody.Truncated() {
+		r.Content.Comment = "body truncated by capture limit"
+	}
+	return r
+}
+
+// isTextual reports whether a media type is safe to embed as text
+func isTextual(mediatype string) bool {
+	switch mediatype {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-www-form-urlencoded", "image/svg+xml":
+		return true
+	}
+	return strings.HasPrefix(mediatype, "text/") ||
+		strings.HasSuffix(mediatype, "+json") || strings.HasSuffix(mediatype, "+xml")
+}
+
+// newHAR builds a HAR document from exchanges, oldest first
+func newHAR(exchanges []*Exchange) *HAR {
+	har := &HAR{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "echogy", Version: harVersion()},
+		Entries: make([]harEntry, 0, len(exchanges)),
+	}}
+	for _, e := range exchanges {
+		entry := harEntry{
+			StartedDateTime: e.StartTime.Format(time.RFC3339Nano),
+			Time:            e.UseTime,
+			Request:         harRequestOf(e),
+			Response:        harResponseOf(e),
+			Timings:         harTimings{Blocked: -1, DNS: -1, Connect: -1, Send: 0, Wait: e.UseTime, Receive: 0, SSL: -1},
+		}
+		if e.Replay {
+			entry.Comment = "replayed from the echogy dashboard"
+		}
+		if e.Edge != "" {
+			entry.Comment = e.Edge + " answered by the echogy edge"
+		}
+		har.Log.Entries = append(har.Log.Entries, entry)
+	}
+	return har
+}
+
+// marshalHAR encodes the exchanges as an indented HAR document
+func marshalHAR(exchanges []*Exchange) ([]byte, error) {
+	return json.MarshalIndent(newHAR(exchanges), "", "  ")
+}
+
+// HAR exports the exchanges kept by the dashboard as a HAR 1.2 json document
+func (t *Tui) HAR() ([]byte, error) {
+	return marshalHAR(t.dashboard.exchanges())
+}
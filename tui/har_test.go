@@ -0,0 +1,89 @@
+package tui
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youkale/echogy/pkg/capture"
+)
+
+func TestMarshalHAR(t *testing.T) {
+	e := testExchange(t, "POST /hook?x=1 HTTP/1.1\r\nHost: a.webs.sh\r\nContent-Type: application/json\r\nContent-Length: 7\r\n\r\n{\"a\":1}", capture.DefaultLimit)
+	br := bufio.NewReader(strings.NewReader("HTTP/1.1 200 OK\r\nContent-Type: image/png\r\nContent-Length: 4\r\n\r\n\x89PNG"))
+	resp, err := http.ReadResponse(br, e.Request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Response = resp
+	e.ResponseBody = capture.New(capture.DefaultLimit)
+	io.Copy(e.ResponseBody, br)
+	e.UseTime = 12
+	e.StartTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	data, err := marshalHAR([]*Exchange{e})
+	if err != nil {
+		t.Fatal(err)
+	}
+	har := &HAR{}
+	if err := json.Unmarshal(data, har); err != nil {
+		t.Fatal(err)
+	}
+	if len(har.Log.Entries) != 1 {
+		t.Fatalf("entries = %d, want 1", len(har.Log.Entries))
+	}
+	entry := har.Log.Entries[0]
+	if entry.StartedDateTime != "2024-01-02T03:04:05Z" || entry.Time != 12 {
+		t.Errorf("timing = %s %d", entry.StartedDateTime, entry.Time)
+	}
+	if entry.Request.URL != "http://a.webs.sh/hook?x=1" {
+		t.Errorf("url = %q", entry.Request.URL)
+	}
+	if entry.Request.PostData == nil || entry.Request.PostData.Text != `{"a":1}` {
+		t.Errorf("postData = %+v", entry.Request.PostData)
+	}
+	if len(entry.Request.QueryString) != 1 || entry.Request.QueryString[0].Value != "1" {
+		t.Errorf("queryString = %+v", entry.Request.QueryString)
+	}
+	content := entry.Response.Content
+	if content.Encoding != "base64" || content.Text != "iVBORw==" || content.Size != 4 {
+		t.Errorf("content = %+v", content)
+	}
+}
+
+func TestHARCompression(t *testing.T) {
+	e := testExchange(t, "GET / HTTP/1.1\r\nHost: a.webs.sh\r\n\r\n", capture.DefaultLimit)
+	page := strings.Repeat("hello echogy ", 100)
+	gz := &bytes.Buffer{}
+	w := gzip.NewWriter(gz)
+	io.WriteString(w, page)
+	w.Close()
+	wire := &bytes.Buffer{}
+	cw := httputil.NewChunkedWriter(wire)
+	cw.Write(gz.Bytes()[:10])
+	cw.Write(gz.Bytes()[10:])
+	cw.Close()
+	wire.WriteString("\r\n")
+
+	head := "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nTransfer-Encoding: chunked\r\n\r\n"
+	br := bufio.NewReader(strings.NewReader(head + wire.String()))
+	resp, err := http.ReadResponse(br, e.Request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.Response = resp
+	e.ResponseBody = capture.New(capture.DefaultLimit)
+	io.Copy(e.ResponseBody, br)
+
+	content := harResponseOf(e).Content
+	if content.Size != int64(len(page)) || content.Compression != int64(len(page)-gz.Len()) {
+		t.Errorf("size %d, compression %d, want %d, %d", content.Size, content.Compression, len(page), len(page)-gz.Len())
+	}
+}
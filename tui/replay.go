@@ -0,0 +1,140 @@
+package tui
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Replay sends a request through the tunnel again, the resulting exchange is notified as a replay
+type Replay func(req *http.Request)
+
+// requestBody returns the decoded body of the exchange request, failing when it can't be replayed faithfully
+func requestBody(e *Exchange) ([]byte, error) {
+	if e.RequestBody == nil {
+		if e.Request.ContentLength != 0 {
+			return nil, errors.New("request body was not captured")
+		}
+		return nil, nil
+	}
+	if e.RequestBody.Truncated() {
+		return nil, fmt.Errorf("request body was truncated at %s", HumanBytes(int64(e.RequestBody.Len())))
+	}
+	return dechunk(e.RequestBody.Bytes(), e.Request.TransferEncoding), nil
+}
+
+// withBody turns a parsed request into one that can be written to a backend again
+func withBody(req *http.Request, body []byte) *http.Request {
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.TransferEncoding = nil
+	req.Header.Del("Content-Length")
+	req.RequestURI = ""
+	// one request per channel, let the backend close it once answered
+	req.Close = true
+	return req
+}
+
+// replayRequest builds the request to replay for an exchange
+func replayRequest(e *Exchange) (*http.Request, error) {
+	body, err := requestBody(e)
+	if err != nil {
+		return nil, err
+	}
+	return withBody(e.Request.Clone(context.Background()), body), nil
+}
+
+// dumpRequest renders a request as editable text, with the body decoded
+func dumpRequest(e *Exchange) (string, error) {
+	body, err := requestBody(e)
+	if err != nil {
+		return "", err
+	}
+	if isBinary(body) {
+		return "", errors.New("binary request bodies can't be edited")
+	}
+	b := &strings.Builder{}
+	req := e.Request
+	// replayed requests have no RequestURI, it is cleared to write them again
+	fmt.Fprintf(b, "%s %s HTTP/1.1\n", req.Method, req.URL.RequestURI())
+	fmt.Fprintf(b, "Host: %s\n", req.Host)
+	for _, name := range sortedKeys(req.Header) {
+		if name == "Content-Length" || name == "Transfer-Encoding" {
+			continue
+		}
+		for _, v := range req.Header[name] {
+			fmt.Fprintf(b, "%s: %s\n", name, v)
+		}
+	}
+	b.WriteString("\n")
+	b.Write(body)
+	return b.String(), nil
+}
+
+// parseEditedRequest parses the text of the request editor, the body is everything after the first blank line
+func parseEditedRequest(text string) (*http.Request, error) {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	head, body, _ := strings.Cut(text, "\n\n")
+	head = strings.ReplaceAll(strings.TrimSpace(head), "\n", "\r\n") + "\r\n\r\n"
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(head)))
+	if err != nil {
+		return nil, err
+	}
+	return withBody(req, []byte(body)), nil
+}
+
+// requestEditor lets the user change a request before replaying it
+type requestEditor struct {
+	textarea textarea.Model
+	err      string
+}
+
+func newRequestEditor(text string, width, height int) *requestEditor {
+	ta := textarea.New()
+	ta.CharLimit = 0
+	ta.MaxHeight = 0
+	ta.ShowLineNumbers = false
+	ta.SetWidth(width)
+	ta.SetHeight(height)
+	ta.SetValue(text)
+	ta.Focus()
+	return &requestEditor{textarea: ta}
+}
+
+func (r *requestEditor) setSize(width, height int) {
+	r.textarea.SetWidth(width)
+	r.textarea.SetHeight(height)
+}
+
+func (r *requestEditor) Update(msg tea.Msg) tea.Cmd {
+	var cmd tea.Cmd
+	r.textarea, cmd = r.textarea.Update(msg)
+	return cmd
+}
+
+// request parses the edited text, keeping the error for display when it is invalid
+func (r *requestEditor) request() *http.Request {
+	req, err := parseEditedRequest(r.textarea.Value())
+	if err != nil {
+		r.err = err.Error()
+		return nil
+	}
+	return req
+}
+
+func (r *requestEditor) View() string {
+	footer := hintStyle.Render("ctrl+s send • esc cancel")
+	if r.err != "" {
+		footer = lipgloss.JoinHorizontal(lipgloss.Left, errorStyle.Render(r.err+"  "), footer)
+	}
+	return lipgloss.JoinVertical(lipgloss.Left, detailStyle.Render(r.textarea.View()), footer)
+}
@@ -0,0 +1,107 @@
+package tui
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/youkale/echogy/pkg/capture"
+)
+
+func testExchange(t *testing.T, raw string, limit int) *Exchange {
+	t.Helper()
+	br := bufio.NewReader(strings.NewReader(raw))
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := capture.New(limit)
+	io.Copy(body, br)
+	return &Exchange{Request: req, RequestBody: body}
+}
+
+func TestReplayRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		limit    int
+		wantBody string
+		wantErr  bool
+	}{
+		{
+			name:     "content length",
+			raw:      "POST /hook?x=1 HTTP/1.1\r\nHost: a.webs.sh\r\nContent-Length: 5\r\n\r\nhello",
+			limit:    capture.DefaultLimit,
+			wantBody: "hello",
+		},
+		{
+			name:     "chunked",
+			raw:      "POST /hook HTTP/1.1\r\nHost: a.webs.sh\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			limit:    capture.DefaultLimit,
+			wantBody: "hello",
+		},
+		{
+			name:    "truncated",
+			raw:     "POST /hook HTTP/1.1\r\nHost: a.webs.sh\r\nContent-Length: 5\r\n\r\nhello",
+			limit:   2,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := replayRequest(testExchange(t, tt.raw, tt.limit))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replayRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			out := &strings.Builder{}
+			if err := req.Write(out); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(out.String(), "\r\n\r\n"+tt.wantBody) {
+				t.Errorf("replayed request = %q, want body %q", out.String(), tt.wantBody)
+			}
+			if !strings.Contains(out.String(), "Host: a.webs.sh\r\n") {
+				t.Errorf("replayed request = %q, want the original host", out.String())
+			}
+		})
+	}
+}
+
+func TestEditRequest(t *testing.T) {
+	e := testExchange(t, "POST /hook HTTP/1.1\r\nHost: a.webs.sh\r\nX-Token: a\r\nContent-Length: 5\r\n\r\nhello", capture.DefaultLimit)
+	text, err := dumpRequest(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "POST /hook HTTP/1.1\nHost: a.webs.sh\nX-Token: a\n\nhello"
+	if text != want {
+		t.Fatalf("dumpRequest() = %q, want %q", text, want)
+	}
+
+	edited := strings.Replace(strings.Replace(text, "X-Token: a", "X-Token: b", 1), "hello", "hello world", 1)
+	req, err := parseEditedRequest(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Header.Get("X-Token") != "b" || req.ContentLength != 11 {
+		t.Errorf("parseEditedRequest() header = %v, length %d", req.Header, req.ContentLength)
+	}
+
+	// a replayed request is edited again with its path
+	e.Request = req
+	e.RequestBody = nil
+	e.Request.ContentLength = 0
+	if text, err := dumpRequest(e); err != nil || !strings.HasPrefix(text, "POST /hook HTTP/1.1\n") {
+		t.Errorf("dumpRequest() of a replayed request = %q, %v", text, err)
+	}
+
+	if _, err := parseEditedRequest("not a request"); err == nil {
+		t.Error("parseEditedRequest() expected an error for an invalid request line")
+	}
+}
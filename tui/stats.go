@@ -0,0 +1,168 @@
+package tui
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	statsWindow    = 120 // seconds of request rate kept for the sparkline
+	latencySamples = 512 // recent latencies the percentiles are computed from
+	// statsPanelHeight is the number of lines the panel takes, its three rows and border
+	statsPanelHeight = 5
+)
+
+var (
+	sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+	statsPanelStyle = lipgloss.NewStyle().Border(lipgloss.RoundedBorder()).Padding(0, 1)
+
+	statsLabelStyle = lipgloss.NewStyle().Bold(true).Width(6)
+
+	sparkStyle = lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#3182CE", Dark: "#90CDF4"})
+)
+
+// statsTickMsg scrolls the sparkline while the panel is open, even without traffic
+type statsTickMsg time.Time
+
+func statsTick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+		return statsTickMsg(t)
+	})
+}
+
+// stats keeps recent traffic figures for the stats panel
+type stats struct {
+	buckets   [statsWindow]int // requests per second, indexed by unix second
+	last      int64            // unix second of the newest bucket
+	latencies []int64          // ring of recent latencies in milliseconds
+	next      int              // ring position of the next latency
+	classes   [6]int           // responses by status class, 0 for anything outside 1xx-5xx
+}
+
+// advance moves the buckets forward to now, clearing the seconds without traffic
+func (s *stats) advance(now time.Time) {
+	sec := now.Unix()
+	if sec-s.last >= statsWindow {
+		s.buckets = [statsWindow]int{}
+		s.last = sec
+		return
+	}
+	for s.last < sec {
+		s.last++
+		s.buckets[s.last%statsWindow] = 0
+	}
+}
+
+func (s *stats) add(e *Exchange, now time.Time) {
+	s.advance(now)
+	s.buckets[s.last%statsWindow]++
+
+	if len(s.latencies) < latencySamples {
+		s.latencies = append(s.latencies, e.UseTime)
+	} else {
+		s.latencies[s.next] = e.UseTime
+	}
+	s.next = (s.next + 1) % latencySamples
+
+	class := e.Response.StatusCode / 100
+	if class < 1 || class > 5 {
+		class = 0
+	}
+	s.classes[class]++
+}
+
+// rates returns the requests per second of the last n complete seconds, oldest first
+func (s *stats) rates(n int) []int {
+	n = min(n, statsWindow-1)
+	rates := make([]int, n)
+	for i := range rates {
+		rates[i] = s.buckets[(s.last-int64(n-i))%statsWindow]
+	}
+	return rates
+}
+
+// percentiles returns the latency in milliseconds at each of ps, which are between 0 and 100
+func (s *stats) percentiles(ps ...float64) []int64 {
+	values := make([]int64, len(ps))
+	if len(s.latencies) == 0 {
+		return values
+	}
+	sorted := slices.Clone(s.latencies)
+	slices.Sort(sorted)
+	for i, p := range ps {
+		// nearest rank
+		rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+		values[i] = sorted[max(rank, 0)]
+	}
+	return values
+}
+
+// sparkline renders values scaled to the largest one
+func sparkline(values []int) string {
+	peak := slices.Max(append(values, 0))
+	b := &strings.Builder{}
+	for _, v := range values {
+		if peak == 0 {
+			b.WriteRune(' ')
+			continue
+		}
+		b.WriteRune(sparkTicks[v*(len(sparkTicks)-1)/peak])
+	}
+	return b.String()
+}
+
+// statusBar renders the share of each status class as a bar of width cells
+func (s *stats) statusBar(width int) string {
+	total := 0
+	for _, n := range s.classes {
+		total += n
+	}
+	if total == 0 || width <= 0 {
+		return hintStyle.Render("no responses yet")
+	}
+	var bar, legend []string
+	for _, class := range []int{2, 3, 4, 5, 1, 0} {
+		n := s.classes[class]
+		if n == 0 {
+			continue
+		}
+		style := lipgloss.NewStyle().Foreground(statusColor(class * 100))
+		bar = append(bar, style.Render(strings.Repeat("█", max(n*width/total, 1))))
+		name := "other"
+		if class > 0 {
+			name = fmt.Sprintf("%dxx", class)
+		}
+		legend = append(legend, style.Render(fmt.Sprintf("%s %d%%", name, n*100/total)))
+	}
+	return strings.Join(bar, "") + "  " + strings.Join(legend, " ")
+}
+
+// view renders the panel in width columns, the labels are dropped on narrow terminals
+func (s *stats) view(width int) string {
+	inner := max(width-4, 10)
+	label := func(l string) string {
+		if inner < 60 {
+			return ""
+		}
+		return statsLabelStyle.Render(l)
+	}
+	p := s.percentiles(50, 95, 99)
+	latency := fmt.Sprintf("p50 %s  p95 %s  p99 %s", humanMillis(p[0]), humanMillis(p[1]), humanMillis(p[2]))
+
+	rates := s.rates(max(inner-lipgloss.Width(label("rps"))-10, 1))
+	rps := sparkStyle.Render(sparkline(rates)) + fmt.Sprintf(" %d/s", rates[len(rates)-1])
+
+	barWidth := max(inner-lipgloss.Width(label("codes"))-30, 1)
+	return statsPanelStyle.Width(width - 2).Render(lipgloss.JoinVertical(lipgloss.Left,
+		label("rps")+rps,
+		label("time")+latency,
+		label("codes")+s.statusBar(barWidth),
+	))
+}
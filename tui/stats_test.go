@@ -0,0 +1,54 @@
+package tui
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	s := &stats{}
+	start := time.Unix(1700000000, 0)
+	for i := 1; i <= 100; i++ {
+		at := start.Add(time.Duration((i-1)/25) * time.Second)
+		status := http.StatusOK
+		if i%10 == 0 {
+			status = http.StatusBadGateway
+		}
+		s.add(&Exchange{Response: &http.Response{StatusCode: status}, UseTime: int64(i)}, at)
+	}
+	s.advance(start.Add(4 * time.Second))
+
+	if got, want := s.rates(5), []int{0, 25, 25, 25, 25}; !slices.Equal(got, want) {
+		t.Errorf("rates(5) = %v, want %v", got, want)
+	}
+	if got := s.percentiles(50, 95, 99); got[0] != 50 || got[1] != 95 || got[2] != 99 {
+		t.Errorf("percentiles = %v", got)
+	}
+	if s.classes[2] != 90 || s.classes[5] != 10 {
+		t.Errorf("classes = %v", s.classes)
+	}
+
+	// a long quiet spell clears the rate history
+	s.advance(start.Add(time.Hour))
+	if got := s.rates(5); !slices.Equal(got, make([]int, 5)) {
+		t.Errorf("rates after an hour = %v", got)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		values []int
+		want   string
+	}{
+		{values: []int{0, 0, 0}, want: "   "},
+		{values: []int{0, 7, 14}, want: "▁▄█"},
+		{values: []int{1}, want: "█"},
+	}
+	for _, tt := range tests {
+		if got := sparkline(tt.values); got != tt.want {
+			t.Errorf("sparkline(%v) = %q, want %q", tt.values, got, tt.want)
+		}
+	}
+}
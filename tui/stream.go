@@ -0,0 +1,159 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/youkale/echogy/pkg/wsframe"
+)
+
+const (
+	// maxStreamFrames is how many frames a stream keeps for the frame viewer
+	maxStreamFrames = 200
+	// FramePreview is how many payload bytes are kept per frame
+	FramePreview = 256
+)
+
+// Stream is what a connection carries after switching protocols, it is updated while the dashboard shows it
+type Stream struct {
+	Protocol string // value of the Upgrade header, "tunnel" after a CONNECT
+
+	mu       sync.RWMutex
+	messages [2]int   // complete messages, from the client then from the server
+	bytes    [2]int64 // bytes, from the client then from the server
+	frames   []StreamFrame
+	dropped  int  // frames no longer kept
+	keep     bool // keep frames for the frame viewer
+	closed   bool
+	closedAt time.Time
+}
+
+// StreamFrame is a websocket frame seen on a stream
+type StreamFrame struct {
+	*wsframe.Frame
+	FromClient bool
+	At         time.Time
+}
+
+// NewStream starts following an upgraded connection, frames are kept when keepFrames is set
+func NewStream(protocol string, keepFrames bool) *Stream {
+	return &Stream{Protocol: protocol, keep: keepFrames}
+}
+
+func side(fromClient bool) int {
+	if fromClient {
+		return 0
+	}
+	return 1
+}
+
+// Bytes counts n bytes that aren't part of a parsed frame
+func (s *Stream) Bytes(fromClient bool, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bytes[side(fromClient)] += n
+}
+
+// Frame records a websocket frame
+func (s *Stream) Frame(fromClient bool, f *wsframe.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := side(fromClient)
+	s.bytes[i] += f.Size
+	if f.EndsMessage() {
+		s.messages[i]++
+	}
+	if !s.keep {
+		return
+	}
+	if len(s.frames) == maxStreamFrames {
+		s.frames = s.frames[1:]
+		s.dropped++
+	}
+	s.frames = append(s.frames, StreamFrame{Frame: f, FromClient: fromClient, At: time.Now()})
+}
+
+// Close marks the stream as ended
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.closedAt = time.Now()
+	}
+}
+
+// Live reports whether the stream is still open
+func (s *Stream) Live() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.closed
+}
+
+// Messages returns the number of complete messages each side sent
+func (s *Stream) Messages() (fromClient, fromServer int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.messages[0], s.messages[1]
+}
+
+// summary renders the counters for the request table
+func (s *Stream) summary() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	state := "●"
+	if s.closed {
+		state = "○"
+	}
+	if s.Protocol != "websocket" {
+		return fmt.Sprintf("%s %s ↑%s ↓%s", state, s.Protocol, HumanBytes(s.bytes[0]), HumanBytes(s.bytes[1]))
+	}
+	return fmt.Sprintf("%s ws ↑%d ↓%d msgs %s", state, s.messages[0], s.messages[1], HumanBytes(s.bytes[0]+s.bytes[1]))
+}
+
+// renderStream renders the stream counters and its kept frames for the detail pane
+func renderStream(b *strings.Builder, s *Stream) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b.WriteString(sectionStyle.Render("Upgraded to "+s.Protocol) + "\n")
+	state := "open"
+	if s.closed {
+		state = "closed at " + s.closedAt.Format(time.TimeOnly)
+	}
+	fmt.Fprintf(b, "  %s  client → %d msgs %s  server → %d msgs %s\n", state,
+		s.messages[0], HumanBytes(s.bytes[0]), s.messages[1], HumanBytes(s.bytes[1]))
+	if s.Protocol != "websocket" {
+		return
+	}
+	if !s.keep {
+		b.WriteString(hintStyle.Render("  frames aren't kept, start the tunnel with frames=on to list them") + "\n")
+		return
+	}
+	b.WriteString(sectionStyle.Render("Frames") + "\n")
+	if s.dropped > 0 {
+		b.WriteString(hintStyle.Render(fmt.Sprintf("  %d older frames not kept", s.dropped)) + "\n")
+	}
+	for _, f := range s.frames {
+		arrow := "←"
+		if f.FromClient {
+			arrow = "→"
+		}
+		fmt.Fprintf(b, "  %s %s %-6s %7s  %s\n", f.At.Format("15:04:05.000"), arrow, f.Opcode,
+			HumanBytes(f.Length), framePreview(f.Frame))
+	}
+}
+
+// framePreview renders the start of a text frame on one line
+func framePreview(f *wsframe.Frame) string {
+	if f.Opcode != wsframe.Text {
+		return ""
+	}
+	// the preview may end in the middle of a character
+	text := strings.ReplaceAll(strings.ToValidUTF8(string(f.Payload), ""), "\n", "⏎")
+	if int64(len(f.Payload)) < f.Length {
+		text += "…"
+	}
+	return text
+}
@@ -0,0 +1,40 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/youkale/echogy/pkg/wsframe"
+)
+
+func TestRenderStream(t *testing.T) {
+	s := NewStream("websocket", true)
+	s.Frame(true, &wsframe.Frame{Fin: true, Opcode: wsframe.Text, Length: 2, Size: 8, Payload: []byte("hi")})
+	s.Frame(false, &wsframe.Frame{Fin: false, Opcode: wsframe.Text, Length: 600, Size: 604, Payload: []byte("line\nnext")})
+	s.Frame(false, &wsframe.Frame{Fin: true, Opcode: wsframe.Continuation, Length: 10, Size: 12})
+	s.Frame(false, &wsframe.Frame{Fin: true, Opcode: wsframe.Ping, Size: 2})
+	s.Bytes(false, 100)
+
+	if got := s.summary(); got != "● ws ↑1 ↓1 msgs 726B" {
+		t.Errorf("summary() = %q", got)
+	}
+	b := &strings.Builder{}
+	renderStream(b, s)
+	for _, want := range []string{"Upgraded to websocket", "client → 1 msgs 8B", "server → 1 msgs 718B",
+		"→ text", "hi", "line⏎next…", "← cont", "← ping"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("rendered stream lacks %q:\n%s", want, b)
+		}
+	}
+
+	s.Close()
+	if s.Live() || !strings.HasPrefix(s.summary(), "○") {
+		t.Errorf("closed stream summary = %q", s.summary())
+	}
+
+	tunnel := NewStream("tunnel", false)
+	tunnel.Bytes(true, 2048)
+	if got := tunnel.summary(); got != "● tunnel ↑2.0K ↓0B" {
+		t.Errorf("summary() = %q", got)
+	}
+}
@@ -12,21 +12,79 @@ import (
 	"github.com/gliderlabs/ssh"
 	"github.com/muesli/termenv"
 	"github.com/youkale/echogy/logger"
+	"github.com/youkale/echogy/pkg/capture"
+	q "github.com/youkale/echogy/pkg/queue"
 )
 
 type Tui struct {
 	*tea.Program
-	exchangeChan chan *httpExchange
+	dashboard    *Dashboard
+	exchangeChan chan *Exchange
+	done         <-chan struct{} // closed with the session, nothing receives exchanges after
 }
 
-type httpExchange struct {
+// Exchange is a request and its response observed on a tunnel
+type Exchange struct {
 	*http.Response
 	*http.Request
-	useTime int64
+	UseTime      int64           // milliseconds from request to response
+	RequestBody  *capture.Buffer // captured request body, nil when capturing is disabled
+	ResponseBody *capture.Buffer // captured response body, nil when capturing is disabled
+	Replay       bool            // sent from the dashboard rather than by a facade client
+	StartTime    time.Time       // when the request started
+	Stream       *Stream         // what the connection carried after switching protocols, nil when it didn't
+	Failure      string          // why the tunnel couldn't carry the request, the response is the error page sent instead
+	Edge         string          // what the edge answered the request as without the tunnel, like "CORS preflight"
+	Compression  *Compression    // how the response body was encoded on the tunnel and for the client, nil unless the tunnel compresses
+	seq          int             // number shown in the dashboard, stays with the exchange as the history rolls
 }
 
-func (t *Tui) Notify(w *http.Response, r *http.Request, useTime int64) {
-	t.exchangeChan <- &httpExchange{Response: w, Request: r, useTime: useTime}
+// Notify shows an exchange on the dashboard, it is dropped once the session is gone
+func (t *Tui) Notify(e *Exchange) {
+	select {
+	case t.exchangeChan <- e:
+	case <-t.done:
+	}
+}
+
+// SetReplay enables replaying requests from the dashboard, it must be called before Start
+func (t *Tui) SetReplay(replay Replay) {
+	t.dashboard.replay = replay
+}
+
+// SetExport enables exporting the captured traffic from the dashboard, it must be called before Start
+func (t *Tui) SetExport(export Export) {
+	t.dashboard.export = export
+}
+
+// SetHistory sets how many exchanges the dashboard keeps, it must be called before Start
+func (t *Tui) SetHistory(size int) {
+	t.dashboard.requests = q.NewFixedQueue(size)
+}
+
+// Traffic updates the byte counters shown in the dashboard header
+func (t *Tui) Traffic(recv, sent int64) {
+	t.Send(trafficMsg{recv: recv, sent: sent})
+}
+
+// Pool shows the number of sessions sharing the tunnel and the share of connections this one got
+func (t *Tui) Pool(size int, share float64) {
+	t.Send(poolMsg{size: size, share: share})
+}
+
+// Splits shows the traffic splits the tunnel is a target of, one summary per line
+func (t *Tui) Splits(lines []string) {
+	t.Send(splitsMsg{lines: lines})
+}
+
+// Notice shows a message in the dashboard footer
+func (t *Tui) Notice(text string) {
+	t.Send(noticeMsg{text: text})
+}
+
+// Alert shows an error in the dashboard footer
+func (t *Tui) Alert(err error) {
+	t.Send(noticeMsg{text: err.Error(), isErr: true})
 }
 
 func (t *Tui) Start() error {
@@ -63,7 +121,7 @@ const (
 )
 
 // NewPty creates a new terminal UI instance
-func NewPty(sess ssh.Session, addr string) (*Tui, error) {
+func NewPty(sess ssh.Session, addr string, aliases ...string) (*Tui, error) {
 	pty, windowCh, hasPty := sess.Pty()
 	if !hasPty {
 		return nil, errors.New("no pty")
@@ -80,8 +138,8 @@ func NewPty(sess ssh.Session, addr string) (*Tui, error) {
 		termenv.WithProfile(termenv.ANSI256))
 
 	// Initialize dashboard
-	exChan := make(chan *httpExchange, 2)
-	m := newDashboard(addr, pty.Window.Width, pty.Window.Height, func() {
+	exChan := make(chan *Exchange, 2)
+	m := newDashboard(addr, aliases, pty.Window.Width, pty.Window.Height, func() {
 		time.AfterFunc(200*time.Millisecond, func() {
 			if sess != nil {
 				sess.Close()
@@ -106,8 +164,8 @@ func NewPty(sess ssh.Session, addr string) (*Tui, error) {
 				p.Quit()
 				return
 			case exch := <-exChan:
-				m.AddRequest(exch)
-				p.Send(tea.ShowCursor())
+				// hand the exchange to the event loop so the dashboard is only mutated from Update
+				p.Send(exch)
 			case newSize := <-windowCh:
 				if newSize.Height == 0 || newSize.Width == 0 {
 					continue
@@ -122,7 +180,9 @@ func NewPty(sess ssh.Session, addr string) (*Tui, error) {
 
 	return &Tui{
 		Program:      p,
+		dashboard:    m,
 		exchangeChan: exChan,
+		done:         ctx.Done(),
 	}, nil
 }
 
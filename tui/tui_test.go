@@ -0,0 +1,24 @@
+package tui
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNotifyAfterSession(t *testing.T) {
+	done := make(chan struct{})
+	tui := &Tui{exchangeChan: make(chan *Exchange), done: done}
+	close(done)
+
+	notified := make(chan struct{})
+	go func() {
+		// nothing receives once the session is gone
+		tui.Notify(&Exchange{})
+		close(notified)
+	}()
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Fatal("Notify blocked after the session closed")
+	}
+}
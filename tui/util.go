@@ -24,7 +24,8 @@ const (
 	TB                    // 1 << (10*4)
 )
 
-func humanBytes(i int64) string {
+// HumanBytes formats a byte count with a binary unit
+func HumanBytes(i int64) string {
 	bytes := float64(i)
 	switch {
 	case bytes < KB:
@@ -47,7 +48,7 @@ func humanSize(size string) string {
 	if err != nil {
 		return ""
 	}
-	return humanBytes(atoi)
+	return HumanBytes(atoi)
 }
 
 // HumanMillis converts milliseconds to a human-readable duration string
@@ -4,8 +4,11 @@ import (
 	"crypto/rand"
 	"fmt"
 	"github.com/karlseguin/ccache/v3"
+	"github.com/youkale/echogy/pkg/capture"
+	"io"
 	"net"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -92,3 +95,41 @@ func parseHostAddr(addr string) (string, uint32, error) {
 	}
 	return host, uint32(port), nil
 }
+
+// requestedAccessId returns the tunnel name asked for through the bind address of
+// `ssh -R name:80:localhost:3000`, or an empty string when the server should pick one
+func requestedAccessId(bindAddr string) string {
+	name := strings.ToLower(bindAddr)
+	switch name {
+	case "", "localhost", "*", "0.0.0.0", "::", "127.0.0.1", "::1":
+		return ""
+	}
+	if !validAccessId(name) {
+		return ""
+	}
+	return name
+}
+
+// validAccessId reports whether name can be used as a single dns label
+func validAccessId(name string) bool {
+	if len(name) == 0 || len(name) > 63 {
+		return false
+	}
+	if name[0] == '-' || name[len(name)-1] == '-' {
+		return false
+	}
+	for _, c := range name {
+		if !strings.ContainsRune(AlphaNum, c) && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// orDiscard returns w, or io.Discard when w is nil
+func orDiscard(w *capture.Buffer) io.Writer {
+	if nil == w {
+		return io.Discard
+	}
+	return w
+}
@@ -243,6 +243,32 @@ func TestParseHostAddrSimple(t *testing.T) {
 	}
 }
 
+func TestRequestedAccessId(t *testing.T) {
+	tests := []struct {
+		bindAddr string
+		want     string
+	}{
+		{bindAddr: "", want: ""},
+		{bindAddr: "localhost", want: ""},
+		{bindAddr: "0.0.0.0", want: ""},
+		{bindAddr: "myapp", want: "myapp"},
+		{bindAddr: "MyApp", want: "myapp"},
+		{bindAddr: "my-app-2", want: "my-app-2"},
+		{bindAddr: "-myapp", want: ""},
+		{bindAddr: "my.app", want: ""},
+		{bindAddr: "my_app", want: ""},
+		{bindAddr: strings.Repeat("a", 64), want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.bindAddr, func(t *testing.T) {
+			if got := requestedAccessId(tt.bindAddr); got != tt.want {
+				t.Errorf("requestedAccessId(%q) = %q, want %q", tt.bindAddr, got, tt.want)
+			}
+		})
+	}
+}
+
 // Helper function to check if a string contains only characters from a given set
 func containsOnlyChars(s, chars string) bool {
 	for _, c := range s {